@@ -0,0 +1,86 @@
+package ensureinterval
+
+import (
+	"context"
+	"time"
+)
+
+// DisableJob stops the named job from being run until it is enabled
+// again with EnableJob.
+func (r *Runner) DisableJob(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.disabled[name] = true
+}
+
+// EnableJob enables the named job again after it was disabled with
+// DisableJob, or by the Runner for reaching its
+// MaxConsecutiveFailures. A job with Disabled set stays disabled.
+func (r *Runner) EnableJob(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.disabled, name)
+	if stat, ok := r.stats[name]; ok {
+		stat.ConsecutiveFailures = 0
+	}
+}
+
+// isDisabled returns true if the job is disabled, or the Runner has
+// disabled it or its group
+func (r *Runner) isDisabled(j *Job) bool {
+	if j.Disabled {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.disabled[j.Name] || (j.Group != "" && r.disabledGroups[j.Group])
+}
+
+// trip disables a job that has failed too many times in a row
+func (r *Runner) trip(job *Job, now time.Time, err error) {
+	r.mu.Lock()
+	r.disabled[job.Name] = true
+	r.mu.Unlock()
+	r.jobLogf(job, now, "disabled after %d consecutive failures", job.MaxConsecutiveFailures)
+	for _, f := range r.onJobDisabled {
+		f(job.Name, err)
+	}
+}
+
+// setBackoff sets when a job may next run after failures failures in
+// a row, clearing it if there were none
+func (r *Runner) setBackoff(job *Job, now time.Time, failures int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if failures == 0 || job.FailureBackoff <= 0 {
+		delete(r.notBefore, job.Name)
+		return
+	}
+	backoff := job.FailureBackoff
+	for i := 1; i < failures; i++ {
+		if backoff *= 2; job.FailureBackoffMax > 0 && backoff > job.FailureBackoffMax {
+			backoff = job.FailureBackoffMax
+			break
+		}
+	}
+	r.notBefore[job.Name] = now.Add(backoff)
+}
+
+// holdOff stops a job that returned an ErrBackoff from running again
+// until d after now. The job's period is the least it is held off for.
+func (r *Runner) holdOff(ctx context.Context, job *Job, now time.Time, d, interval time.Duration) {
+	if period := r.period(job, interval); d < period {
+		d = period
+	}
+	r.mu.Lock()
+	r.notBefore[job.Name] = now.Add(d)
+	r.mu.Unlock()
+	r.runDebugf(ctx, job, "next run in %s", d)
+}
+
+// getBackoff returns the time before which the named job may not run
+func (r *Runner) getBackoff(name string) time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.notBefore[name]
+}
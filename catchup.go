@@ -0,0 +1,175 @@
+package ensureinterval
+
+import (
+	"context"
+	stderrors "errors"
+	"sort"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// CatchupPolicy decides what a Runner does once it has caught up its
+// max catchups and is still behind. It is called with how many
+// intervals were caught up and how far behind the Runner still is. If
+// it returns an error the Runner stops and returns it, if it returns nil
+// the remaining missed intervals are skipped and the Runner carries on
+// from the current interval. PolicyFail and PolicyResync can be used,
+// or a function of its own that, for example, logs or reports the
+// skipped intervals.
+type CatchupPolicy func(caught int, behind time.Duration) error
+
+// PolicyFail is the CatchupPolicy that stops the Runner with an error
+// matching ErrMaxCatchups. It is the default.
+func PolicyFail(caught int, behind time.Duration) error {
+	return &errMaxCatchups{}
+}
+
+// PolicyResync is the CatchupPolicy that skips the remaining missed
+// intervals and carries on from the current interval.
+func PolicyResync(caught int, behind time.Duration) error {
+	return nil
+}
+
+// catchupExceeded applies the Runner's catchup policy once it has
+// caught up its max catchups, returning the error to stop with or nil
+// to skip to the current interval
+func (r *Runner) catchupExceeded(caught int, behind time.Duration) error {
+	policy := r.catchupPolicy
+	if policy == nil {
+		policy = PolicyFail
+	}
+	if err := policy(caught, behind); err != nil {
+		return err
+	}
+	r.logf("max catchups reached %s behind, skipping to the current interval", behind)
+	return nil
+}
+
+// coalescedRun is a run of a job held back by coalesce
+type coalescedRun struct {
+	job *Job
+	at  time.Time
+}
+
+// coalesce holds back the run of a job with CoalesceCatchup for the
+// missed interval at now, keeping only the latest run for each job
+// until runCoalesced is called
+func (r *Runner) coalesce(j *Job, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if t, ok := r.coalesced[j]; !ok || now.After(t) {
+		r.coalesced[j] = now
+	}
+}
+
+// runCoalesced runs the runs held back by coalesce, in the order of
+// the intervals they are for
+func (r *Runner) runCoalesced(ctx context.Context, interval time.Duration) error {
+	r.mu.Lock()
+	runs := make([]coalescedRun, 0, len(r.coalesced))
+	for j, t := range r.coalesced {
+		runs = append(runs, coalescedRun{job: j, at: t})
+	}
+	r.coalesced = map[*Job]time.Time{}
+	r.mu.Unlock()
+	sort.Slice(runs, func(a, b int) bool {
+		return runs[a].at.Before(runs[b].at)
+	})
+	for i := 0; i < len(runs); {
+		at := runs[i].at
+		due := []*Job{}
+		for ; i < len(runs) && runs[i].at.Equal(at); i++ {
+			j := runs[i].job
+			if r.dryRun {
+				r.jobLogf(j, at, "would run for %s", at)
+				continue
+			}
+			if !r.acquire(j) {
+				r.jobLogf(j, at, "skipping, previous run has not finished")
+				continue
+			}
+			due = append(due, j)
+		}
+		if err := r.jobsError(ctx, r.runJobs(ctx, at, interval, due)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// processCatchups runs the jobs due in each of the missed intervals,
+// up to the Runner's concurrent catchups at once, and returns the
+// errors from them that should stop the Runner joined together. Stale catchups
+// are skipped, and no more are started once the Runner is stopped.
+func (r *Runner) processCatchups(ctx context.Context, ketchups []time.Time, interval time.Duration, jobs []*Job) error {
+	if r.concurrentCatchup <= 1 {
+		for _, ketchup := range ketchups {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if r.stopped() {
+				return nil
+			}
+			if r.staleCatchup(ketchup) {
+				r.logf("skipping stale catchup for %s", ketchup)
+				continue
+			}
+			if err := r.jobsError(ctx, r.processJobs(ctx, ketchup, interval, jobs, runCatchup)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	errs := make([]error, len(ketchups))
+	var g errgroup.Group
+	g.SetLimit(r.concurrentCatchup)
+	for i, ketchup := range ketchups {
+		if ctx.Err() != nil || r.stopped() {
+			break
+		}
+		if r.staleCatchup(ketchup) {
+			r.logf("skipping stale catchup for %s", ketchup)
+			continue
+		}
+		i, ketchup := i, ketchup
+		g.Go(func() error {
+			errs[i] = r.jobsError(ctx, r.processJobs(ctx, ketchup, interval, jobs, runCatchup))
+			return nil
+		})
+	}
+	_ = g.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// the intervals ran at once, so none of their errors is first
+	return stderrors.Join(errs...)
+}
+
+// catchupConcurrently catches up the intervals missed since now for
+// Run with concurrent catchups, starting a batch of the intervals that
+// have passed and repeating until none have. It returns how long it has
+// been since the last interval caught up.
+func (r *Runner) catchupConcurrently(ctx context.Context, now time.Time, interval time.Duration, jobs []*Job, since func(time.Time) time.Duration) (time.Duration, error) {
+	last, caught := now, 0
+	for !r.stopped() {
+		var batch []time.Time
+		for t := r.nextBoundary(last, interval); len(batch) < r.concurrentCatchup && since(t) > 0; t = r.nextBoundary(t, interval) {
+			batch = append(batch, t)
+		}
+		if len(batch) == 0 {
+			break
+		}
+		if err := r.processCatchups(ctx, batch, interval, jobs); err != nil {
+			return 0, err
+		}
+		last = batch[len(batch)-1]
+		if caught += len(batch); int64(caught) > r.maxCatchups.Load() {
+			if err := r.catchupExceeded(caught, since(last)); err != nil {
+				return 0, err
+			}
+			return since(now) % interval, nil
+		}
+	}
+	return since(last), nil
+}
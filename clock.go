@@ -0,0 +1,82 @@
+package ensureinterval
+
+import (
+	"time"
+)
+
+// Clock tells the time and waits for it to pass. A Runner uses the
+// system clock unless another is set with WithClock, which lets tests
+// drive the scheduler without sleeping.
+//
+// Since returns the time elapsed since t, a time returned by Now. Like
+// time.Since it isn't affected by the clock being set in between, and
+// is used to measure how long jobs take.
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer delivers a single tick once its duration has passed, like a
+// time.Timer. The Runner stops timers it no longer needs.
+type Timer interface {
+	Chan() <-chan time.Time
+	Stop() bool
+}
+
+// Ticker delivers ticks at intervals, like a time.Ticker
+type Ticker interface {
+	Chan() <-chan time.Time
+	Stop()
+}
+
+// WithClock sets the Clock used by the Runner
+func WithClock(c Clock) Option {
+	return func(r *Runner) {
+		r.clock = c
+	}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Since(t time.Time) time.Duration {
+	return time.Since(t)
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) Chan() <-chan time.Time {
+	return t.C
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) Chan() <-chan time.Time {
+	return t.C
+}
+
+// after returns a channel that receives the time once d has passed on
+// the Runner's clock, and a function to stop the timer, which should be
+// called once it isn't waited on any more so a fake clock isn't left
+// with it
+func (r *Runner) after(d time.Duration) (<-chan time.Time, func()) {
+	t := r.clock.NewTimer(d)
+	return t.Chan(), func() { t.Stop() }
+}
@@ -0,0 +1,103 @@
+package ensureinterval
+
+import (
+	"context"
+	"time"
+)
+
+// RunInfo describes a single run of a job
+type RunInfo struct {
+	// ID identifies the run, and is included in the log messages
+	// about it
+	ID string
+	// Job is the name of the job being run
+	Job string
+	// ScheduledFor is the interval time the run is for. During
+	// catchup this is the missed interval, not the current time.
+	ScheduledFor time.Time
+	// Interval is the interval the Runner is running at
+	Interval time.Duration
+}
+
+type runInfoKey struct{}
+
+type startupCheckKey struct{}
+
+// RunInfoFromContext returns the RunInfo of the run the context passed
+// to a job's Exec belongs to. It returns false if ctx doesn't come
+// from a run.
+func RunInfoFromContext(ctx context.Context) (RunInfo, bool) {
+	info, ok := ctx.Value(runInfoKey{}).(RunInfo)
+	return info, ok
+}
+
+// ScheduledTime returns the interval time the run the context passed
+// to a job's Exec belongs to is for. During catchup this is the missed
+// interval, so time bucketed jobs should use it rather than time.Now.
+// It returns false if ctx doesn't come from a run.
+func ScheduledTime(ctx context.Context) (time.Time, bool) {
+	info, ok := RunInfoFromContext(ctx)
+	return info.ScheduledFor, ok
+}
+
+// baseContext returns the context set with WithContext, or
+// context.Background if there isn't one
+func (r *Runner) baseContext() context.Context {
+	if r.baseCtx == nil {
+		return context.Background()
+	}
+	return r.baseCtx
+}
+
+// bindContext returns a copy of ctx that is also cancelled when the
+// context set with WithContext is, or StopWithTimeout is called, along
+// with a function to release it
+func (r *Runner) bindContext(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	stops := []func() bool{context.AfterFunc(r.drainCtx, func() {
+		cancel(context.Cause(r.drainCtx))
+	})}
+	if r.baseCtx != nil {
+		stops = append(stops, context.AfterFunc(r.baseCtx, func() {
+			cancel(r.baseCtx.Err())
+		}))
+	}
+	return ctx, func() {
+		for _, stop := range stops {
+			stop()
+		}
+		cancel(nil)
+	}
+}
+
+// contextErr returns the error of the context that was cancelled if
+// err is from ctx being cancelled, so cancelling the context set with
+// WithContext returns its error rather than context.Canceled
+func contextErr(ctx context.Context, err error) error {
+	if err != nil && context.Cause(ctx) == errDraining {
+		// the jobs were cancelled by StopWithTimeout, so their errors
+		// are expected
+		return nil
+	}
+	if err != nil && err == ctx.Err() {
+		return context.Cause(ctx)
+	}
+	return err
+}
+
+// withRunInfo returns a copy of ctx carrying info
+func withRunInfo(ctx context.Context, info RunInfo) context.Context {
+	return context.WithValue(ctx, runInfoKey{}, info)
+}
+
+// withStartupCheck returns a copy of ctx marking the run it belongs to
+// as a startup check
+func withStartupCheck(ctx context.Context) context.Context {
+	return context.WithValue(ctx, startupCheckKey{}, true)
+}
+
+// isStartupCheck returns true if ctx belongs to a startup check run
+func isStartupCheck(ctx context.Context) bool {
+	check, _ := ctx.Value(startupCheckKey{}).(bool)
+	return check
+}
@@ -0,0 +1,61 @@
+package ensureinterval
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/robfig/cron/v3"
+)
+
+// cronSchedule returns the parsed schedule for a standard cron spec,
+// caching it so loaders returning new jobs don't cost a parse each
+// interval
+func (r *Runner) cronSchedule(spec string) (cron.Schedule, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s, ok := r.schedules[spec]; ok {
+		return s, nil
+	}
+	s, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, errors.Wrapf(ErrInvalidCron, "%q: %s", spec, err)
+	}
+	r.schedules[spec] = s
+	return s, nil
+}
+
+// cronTime returns t in the location cron schedules are worked out in
+func (r *Runner) cronTime(t time.Time) time.Time {
+	if r.location != nil {
+		return t.In(r.location)
+	}
+	return t
+}
+
+// cronDue returns true if the job's cron schedule fires in the
+// interval ending at now
+func (r *Runner) cronDue(j *Job, now time.Time, interval time.Duration) bool {
+	s, err := r.cronSchedule(j.Cron)
+	if err != nil {
+		// checked when loaded
+		return false
+	}
+	return !s.Next(r.cronTime(now.Add(-interval))).After(now)
+}
+
+// cronNext returns the interval boundary the job's cron schedule next
+// fires at after t
+func (r *Runner) cronNext(j *Job, t time.Time, interval time.Duration) time.Time {
+	s, err := r.cronSchedule(j.Cron)
+	if err != nil {
+		return time.Time{}
+	}
+	next := s.Next(r.cronTime(t))
+	if next.IsZero() {
+		return next
+	}
+	if b := r.truncate(next, interval); b.Equal(next) {
+		return b
+	}
+	return r.nextBoundary(next, interval)
+}
@@ -0,0 +1,94 @@
+package ensureinterval
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// checkDependencies returns an error if a job depends on a job that
+// isn't loaded, or the dependencies form a cycle
+func checkDependencies(jobs []*Job) error {
+	byName := make(map[string]*Job, len(jobs))
+	for _, j := range jobs {
+		byName[j.Name] = j
+	}
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[string]int{}
+	var visit func(j *Job, path []string) error
+	visit = func(j *Job, path []string) error {
+		switch state[j.Name] {
+		case visiting:
+			return errors.Wrapf(ErrDependencyCycle, "%s", strings.Join(append(path, j.Name), " -> "))
+		case visited:
+			return nil
+		}
+		state[j.Name] = visiting
+		for _, d := range j.DependsOn {
+			dep, ok := byName[d]
+			if !ok {
+				return errors.Wrapf(ErrUnknownJob, "job %s depends on %s", j.Name, d)
+			}
+			if err := visit(dep, append(path, j.Name)); err != nil {
+				return err
+			}
+		}
+		state[j.Name] = visited
+		return nil
+	}
+	for _, j := range jobs {
+		if err := visit(j, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// orderJobs returns the jobs in priority order, with each job moved
+// after any of the other jobs it depends on
+func orderJobs(jobs []*Job) []*Job {
+	sorted := make([]*Job, len(jobs))
+	copy(sorted, jobs)
+	sort.SliceStable(sorted, func(a, b int) bool {
+		return sorted[a].Priority > sorted[b].Priority
+	})
+	in := make(map[string]bool, len(sorted))
+	for _, j := range sorted {
+		in[j.Name] = true
+	}
+	placed := make(map[string]bool, len(sorted))
+	ready := func(j *Job) bool {
+		for _, d := range j.DependsOn {
+			if in[d] && !placed[d] {
+				return false
+			}
+		}
+		return true
+	}
+	ordered := make([]*Job, 0, len(sorted))
+	for len(ordered) < len(sorted) {
+		progress := false
+		for _, j := range sorted {
+			if !placed[j.Name] && ready(j) {
+				placed[j.Name] = true
+				ordered = append(ordered, j)
+				progress = true
+				break
+			}
+		}
+		if !progress {
+			// a cycle, which loading rejects, so keep the rest as is
+			for _, j := range sorted {
+				if !placed[j.Name] {
+					placed[j.Name] = true
+					ordered = append(ordered, j)
+				}
+			}
+		}
+	}
+	return ordered
+}
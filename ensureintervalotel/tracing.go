@@ -0,0 +1,44 @@
+// Package ensureintervalotel provides OpenTelemetry tracing for
+// ensureinterval Runners.
+package ensureintervalotel // import "github.com/dangersalad/go-ensureinterval/ensureintervalotel"
+
+import (
+	"context"
+	"time"
+
+	"github.com/dangersalad/go-ensureinterval"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/dangersalad/go-ensureinterval"
+
+// WithTracing returns an Option that starts a span named after the job
+// for every run, or every attempt when the job retries, using a tracer
+// from tp. The span is put in the context passed to the job's Exec, so
+// spans the job starts are its children. Catchup runs get their own
+// spans, with the interval they are catching up in the scheduled_for
+// attribute.
+func WithTracing(tp trace.TracerProvider) ensureinterval.Option {
+	tracer := tp.Tracer(instrumentationName)
+	return ensureinterval.WithJobMiddleware(func(next ensureinterval.ExecFunc, job *ensureinterval.Job) ensureinterval.ExecFunc {
+		return func(ctx context.Context) error {
+			var attrs []attribute.KeyValue
+			if info, ok := ensureinterval.RunInfoFromContext(ctx); ok {
+				attrs = append(attrs,
+					attribute.String("scheduled_for", info.ScheduledFor.Format(time.RFC3339Nano)),
+					attribute.String("interval", info.Interval.String()),
+				)
+			}
+			ctx, span := tracer.Start(ctx, job.Name, trace.WithAttributes(attrs...))
+			defer span.End()
+			err := next(ctx)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			return err
+		}
+	})
+}
@@ -0,0 +1,119 @@
+// Package ensureintervalprom provides Prometheus metrics for
+// ensureinterval Runners.
+package ensureintervalprom // import "github.com/dangersalad/go-ensureinterval/ensureintervalprom"
+
+import (
+	"time"
+
+	"github.com/dangersalad/go-ensureinterval"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector is a prometheus.Collector of metrics about the jobs run by
+// the Runners it is hooked into. Create a Runner with the Collector's
+// Options to hook it in:
+//
+//	c := ensureintervalprom.New()
+//	r := ensureinterval.NewRunner(c.Options()...)
+//	prometheus.MustRegister(c)
+type Collector struct {
+	runs     *prometheus.CounterVec
+	failures *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	running  *prometheus.GaugeVec
+	catchups prometheus.Counter
+	missed   prometheus.Gauge
+	behind   prometheus.Gauge
+}
+
+// New creates a new Collector
+func New() *Collector {
+	return &Collector{
+		runs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "ensureinterval",
+			Name:      "job_runs_total",
+			Help:      "Total number of job runs.",
+		}, []string{"job"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "ensureinterval",
+			Name:      "job_failures_total",
+			Help:      "Total number of job runs that returned an error.",
+		}, []string{"job"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "ensureinterval",
+			Name:      "job_duration_seconds",
+			Help:      "How long job runs took.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"job"}),
+		running: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ensureinterval",
+			Name:      "jobs_running",
+			Help:      "Number of runs of a job in progress.",
+		}, []string{"job"}),
+		catchups: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "ensureinterval",
+			Name:      "catchups_total",
+			Help:      "Total number of times missed intervals were caught up.",
+		}),
+		missed: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "ensureinterval",
+			Name:      "catchup_missed_intervals",
+			Help:      "Number of intervals missed at the last catchup.",
+		}),
+		behind: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "ensureinterval",
+			Name:      "catchup_behind_seconds",
+			Help:      "How far behind schedule the last catchup was.",
+		}),
+	}
+}
+
+// Options returns the options that hook the Collector into a Runner
+func (c *Collector) Options() []ensureinterval.Option {
+	return []ensureinterval.Option{
+		ensureinterval.WithOnJobStart(c.jobStart),
+		ensureinterval.WithOnJobComplete(c.jobComplete),
+		ensureinterval.WithOnCatchup(c.catchup),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.runs.Describe(ch)
+	c.failures.Describe(ch)
+	c.duration.Describe(ch)
+	c.running.Describe(ch)
+	c.catchups.Describe(ch)
+	c.missed.Describe(ch)
+	c.behind.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.runs.Collect(ch)
+	c.failures.Collect(ch)
+	c.duration.Collect(ch)
+	c.running.Collect(ch)
+	c.catchups.Collect(ch)
+	c.missed.Collect(ch)
+	c.behind.Collect(ch)
+}
+
+func (c *Collector) jobStart(name string, scheduledFor time.Time) {
+	c.running.WithLabelValues(name).Inc()
+}
+
+func (c *Collector) jobComplete(name string, scheduledFor time.Time, dur time.Duration, err error) {
+	c.running.WithLabelValues(name).Dec()
+	c.runs.WithLabelValues(name).Inc()
+	if err != nil {
+		c.failures.WithLabelValues(name).Inc()
+	}
+	c.duration.WithLabelValues(name).Observe(dur.Seconds())
+}
+
+func (c *Collector) catchup(missed int, behind time.Duration) {
+	c.catchups.Inc()
+	c.missed.Set(float64(missed))
+	c.behind.Set(behind.Seconds())
+}
@@ -0,0 +1,188 @@
+// Package ensureintervaltest provides helpers for testing code that
+// uses ensureinterval.
+package ensureintervaltest // import "github.com/dangersalad/go-ensureinterval/ensureintervaltest"
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dangersalad/go-ensureinterval"
+)
+
+// FakeClock is an ensureinterval.Clock whose time only moves when
+// Advance or Jump is called. Use it with ensureinterval.WithClock.
+type FakeClock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*waiter
+	// jumped is the total of the jumps made, and reads the total when
+	// each time was returned by Now, so Since can leave out the jumps
+	// made after it
+	jumped time.Duration
+	reads  map[time.Time]time.Duration
+}
+
+type waiter struct {
+	at     time.Time
+	period time.Duration
+	c      chan time.Time
+}
+
+// NewFakeClock creates a FakeClock set to now
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now, reads: map[time.Time]time.Duration{}}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now returns the current fake time
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reads[c.now] = c.jumped
+	return c.now
+}
+
+// Since returns how far the clock has been advanced since t was
+// returned by Now, not counting any jumps since then, the same as
+// time.Since using the monotonic clock. For other times it is the
+// difference from the current fake time.
+func (c *FakeClock) Since(t time.Time) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d := c.now.Sub(t)
+	if jumped, ok := c.reads[t]; ok {
+		d -= c.jumped - jumped
+	}
+	return d
+}
+
+// NewTimer returns a Timer that fires once the clock has been advanced
+// by d. A stopped timer no longer counts towards BlockUntil.
+func (c *FakeClock) NewTimer(d time.Duration) ensureinterval.Timer {
+	w := &waiter{c: make(chan time.Time, 1)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d <= 0 {
+		w.c <- c.now
+		return &fakeTimer{clock: c, w: w}
+	}
+	w.at = c.now.Add(d)
+	c.addWaiter(w)
+	return &fakeTimer{clock: c, w: w}
+}
+
+// NewTicker returns a Ticker that ticks every d of fake time. Like a
+// time.Ticker, ticks are dropped if the last one hasn't been read.
+func (c *FakeClock) NewTicker(d time.Duration) ensureinterval.Ticker {
+	if d <= 0 {
+		panic("ensureintervaltest: non-positive interval for NewTicker")
+	}
+	w := &waiter{period: d, c: make(chan time.Time, 1)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w.at = c.now.Add(d)
+	c.addWaiter(w)
+	return &fakeTicker{clock: c, w: w}
+}
+
+// Advance moves the clock forward by d, firing any timers and tickers
+// that come due along the way in order.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	end := c.now.Add(d)
+	for len(c.waiters) > 0 && !c.waiters[0].at.After(end) {
+		w := c.waiters[0]
+		c.waiters = c.waiters[1:]
+		c.now = w.at
+		select {
+		case w.c <- c.now:
+		default:
+		}
+		if w.period > 0 {
+			w.at = w.at.Add(w.period)
+			c.addWaiter(w)
+		}
+	}
+	c.now = end
+}
+
+// Jump sets the clock forward by d, or back if d is negative, without
+// firing any timers or tickers. It is the same as the system clock
+// being set, with timers still firing after the time they were started
+// with rather than at the wall clock time they were due, and Since not
+// counting the jump.
+func (c *FakeClock) Jump(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.jumped += d
+	c.now = c.now.Add(d)
+	for _, w := range c.waiters {
+		w.at = w.at.Add(d)
+	}
+}
+
+// BlockUntil blocks until at least n timers or tickers are waiting on
+// the clock. This is useful to wait for a Runner to go to sleep before
+// advancing the clock.
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.waiters) < n {
+		c.cond.Wait()
+	}
+}
+
+// addWaiter adds w to the sorted waiters, c.mu must be held
+func (c *FakeClock) addWaiter(w *waiter) {
+	c.waiters = append(c.waiters, w)
+	sort.SliceStable(c.waiters, func(i, j int) bool {
+		return c.waiters[i].at.Before(c.waiters[j].at)
+	})
+	c.cond.Broadcast()
+}
+
+// removeWaiter removes w from the waiters, returning false if it
+// wasn't waiting, c.mu must be held
+func (c *FakeClock) removeWaiter(w *waiter) bool {
+	for i, o := range c.waiters {
+		if o == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	w     *waiter
+}
+
+func (t *fakeTimer) Chan() <-chan time.Time {
+	return t.w.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.removeWaiter(t.w)
+}
+
+type fakeTicker struct {
+	clock *FakeClock
+	w     *waiter
+}
+
+func (t *fakeTicker) Chan() <-chan time.Time {
+	return t.w.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.clock.removeWaiter(t.w)
+}
@@ -0,0 +1,60 @@
+package ensureintervaltest
+
+import (
+	"time"
+
+	"github.com/dangersalad/go-ensureinterval"
+)
+
+// RunFor advances c by span one timer at a time, waiting after each for
+// the Runner to finish the interval and go back to sleep, and returns
+// how many times each job ran meanwhile, keyed by job name. Runs before
+// the Runner first went to sleep are not counted, and jobs that didn't
+// run are left out.
+//
+// The Runner must already be running with c as its clock, using Run or
+// one of its variants rather than RunTicker, and nothing else may wait
+// on c. Jobs may have a Timeout, but c isn't advanced while jobs run,
+// so a job that only returns once its Timeout passes will hang it, as
+// will jobs that retry with a RetryBackoff, which wait on c in the
+// middle of an interval.
+func RunFor(c *FakeClock, r *ensureinterval.Runner, span time.Duration) map[string]int {
+	waitAsleep(r, 0)
+	before := r.Stats()
+	end := c.Now().Add(span)
+	for {
+		c.mu.Lock()
+		if len(c.waiters) == 0 || c.waiters[0].at.After(end) {
+			c.mu.Unlock()
+			break
+		}
+		at := c.waiters[0].at
+		c.mu.Unlock()
+		sleeps := r.Sleeps()
+		c.Advance(at.Sub(c.Now()))
+		// while the Runner sleeps the only timers are its own, so it
+		// either runs the next interval and goes back to sleep, or was
+		// stopped by its max runtime
+		waitAsleep(r, sleeps)
+	}
+	c.Advance(end.Sub(c.Now()))
+	runs := map[string]int{}
+	for name, stat := range r.Stats() {
+		if n := stat.Runs - before[name].Runs; n > 0 {
+			runs[name] = n
+		}
+	}
+	return runs
+}
+
+// waitAsleep waits until the Runner has gone to sleep more than n
+// times, or has stopped
+func waitAsleep(r *ensureinterval.Runner, n uint64) {
+	for r.Sleeps() <= n {
+		select {
+		case <-r.Done():
+			return
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package ensureintervaltest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dangersalad/go-ensureinterval"
+	"github.com/dangersalad/go-ensureinterval/ensureintervaltest"
+)
+
+func TestRunFor(t *testing.T) {
+	c := ensureintervaltest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	r := ensureinterval.NewRunner(ensureinterval.WithClock(c))
+	nop := func(context.Context) error { return nil }
+	jobs := []*ensureinterval.Job{
+		{Name: "every-minute", Exec: nop},
+		{Name: "every-5-minutes", Frequency: 5, Exec: nop},
+		{Name: "hourly", Frequency: 60, Exec: nop},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = r.RunContext(ctx, time.Minute, func() ([]*ensureinterval.Job, error) {
+			return jobs, nil
+		})
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	runs := ensureintervaltest.RunFor(c, r, 15*time.Minute)
+	if runs["every-5-minutes"] != 3 {
+		t.Errorf("expected the 5 minute job to run 3 times in 15 minutes, got %d", runs["every-5-minutes"])
+	}
+	if runs["every-minute"] != 15 {
+		t.Errorf("expected the 1 minute job to run 15 times in 15 minutes, got %d", runs["every-minute"])
+	}
+	if n, ok := runs["hourly"]; ok {
+		t.Errorf("expected the hourly job to be left out, it ran %d times", n)
+	}
+}
@@ -0,0 +1,150 @@
+package ensureinterval
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrMaxCatchups is matched by the error returned from Run when the
+// max catchups are reached. The returned error is temporary.
+var ErrMaxCatchups = errors.New("max catchups reached")
+
+// ErrJobTimeout is matched by the error reported for a job that ran
+// longer than its Timeout.
+var ErrJobTimeout = errors.New("job timed out")
+
+// ErrUnknownJob is matched by the error returned when a job name
+// isn't one the Runner knows about.
+var ErrUnknownJob = errors.New("unknown job")
+
+// ErrUnknownGroup is matched by the error returned when no loaded job
+// is in the group asked for.
+var ErrUnknownGroup = errors.New("unknown job group")
+
+// ErrDuplicateJobName is matched by the error returned when more than
+// one job has the same name.
+var ErrDuplicateJobName = errors.New("duplicate job name")
+
+// ErrJobDone can be returned by a job to say it has nothing more to
+// do. The run is counted as a success and the job is not run again by
+// the Runner, and is removed from its registry if it was added with
+// AddJob.
+var ErrJobDone = errors.New("job done")
+
+// ErrSkipped can be returned by a job to say it found nothing to do.
+// The run is counted in the job's Skipped stat rather than as a run or
+// a failure, so it doesn't reset or add to the consecutive failures
+// that trip MaxConsecutiveFailures or the FailureBackoff, and isn't
+// recorded in the state store. It isn't retried.
+var ErrSkipped = errors.New("job skipped")
+
+// ErrJobDisabled is matched by the error returned when a job can't be
+// triggered because it is disabled.
+var ErrJobDisabled = errors.New("job disabled")
+
+// ErrJobRunning is matched by the error returned when a NoOverlap job
+// can't be run because it is already running.
+var ErrJobRunning = errors.New("job already running")
+
+// ErrNotRunning is returned when the Runner must be running to do
+// something and isn't.
+var ErrNotRunning = errors.New("runner not running")
+
+// ErrDrainTimeout is matched by the error returned by StopWithTimeout
+// when jobs are still running once the timeout has passed.
+var ErrDrainTimeout = errors.New("timed out waiting for jobs to finish")
+
+// errDraining is the cause of the cancelled context of jobs running
+// when StopWithTimeout is called
+var errDraining = errors.New("runner stopping")
+
+// ErrNoJobs is returned when the Runner has no jobs to run, or none
+// left, and was created with WithErrorOnNoJobs.
+var ErrNoJobs = errors.New("no jobs to run")
+
+// ErrInvalidInterval is matched by the error returned when the
+// interval to run with is not positive.
+var ErrInvalidInterval = errors.New("invalid interval")
+
+// ErrInvalidFrequency is matched by the error returned when a job's
+// Frequency is negative, looks like a duration rather than a number of
+// intervals, or is too large to run with the interval.
+var ErrInvalidFrequency = errors.New("invalid job frequency")
+
+// ErrInvalidPeriod is matched by the error returned when a job's
+// Period is not a positive multiple of the interval.
+var ErrInvalidPeriod = errors.New("invalid job period")
+
+// ErrInvalidCron is matched by the error returned when a job's Cron
+// expression can't be parsed.
+var ErrInvalidCron = errors.New("invalid job cron expression")
+
+// ErrDependencyCycle is matched by the error returned when the jobs
+// loaded depend on each other in a cycle.
+var ErrDependencyCycle = errors.New("job dependency cycle")
+
+// JobErrors is returned when one or more jobs fail during an
+// interval. Errors holds the error returned by each failed job, keyed
+// by the job name.
+type JobErrors struct {
+	Errors map[string]error
+}
+
+func (e *JobErrors) Error() string {
+	names := make([]string, 0, len(e.Errors))
+	for name := range e.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msgs := make([]string, 0, len(names))
+	for _, name := range names {
+		msgs = append(msgs, e.Errors[name].Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the individual job errors so they can be matched
+// with errors.Is and errors.As.
+func (e *JobErrors) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// ErrBackoff can be returned by a job to say when it should next run,
+// for jobs that poll more or less often depending on how much there is
+// to do. The run is counted as a success, and the job isn't run again
+// until Next has passed. The job's period, from its Frequency or
+// Period, is the shortest Next can be.
+type ErrBackoff struct {
+	Next time.Duration
+}
+
+func (e *ErrBackoff) Error() string {
+	return fmt.Sprintf("next run in %s", e.Next)
+}
+
+type errMaxCatchups struct {
+}
+
+func (e *errMaxCatchups) Error() string {
+	return ErrMaxCatchups.Error()
+}
+
+func (e *errMaxCatchups) String() string {
+	return e.Error()
+}
+
+func (e *errMaxCatchups) Temporary() bool {
+	return true
+}
+
+func (e *errMaxCatchups) Is(target error) bool {
+	return target == ErrMaxCatchups
+}
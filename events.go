@@ -0,0 +1,87 @@
+package ensureinterval
+
+import (
+	"time"
+)
+
+// EventKind is the kind of an Event
+type EventKind int
+
+const (
+	// EventJobStarted is sent when a job starts, with Job and Time set
+	EventJobStarted EventKind = iota
+	// EventJobCompleted is sent when a job finishes, with Job, Time,
+	// Duration and Err set
+	EventJobCompleted
+	// EventCatchup is sent when the Runner starts catching up missed
+	// intervals, with Missed and Duration (how far behind it is) set
+	EventCatchup
+	// EventTick is sent when the Runner starts an interval, with Time
+	// set
+	EventTick
+	// EventError is sent when jobs in an interval fail, with Err set
+	// to the *JobErrors
+	EventError
+)
+
+// eventBuffer is how many events are kept for a slow consumer before
+// more are dropped
+const eventBuffer = 256
+
+// Event is something that happened in the Runner, sent on the channel
+// returned by Events. Which fields are set depends on the Kind.
+type Event struct {
+	Kind EventKind
+	// Job is the name of the job the event is about
+	Job string
+	// Time is the interval time a job was scheduled for, or the time
+	// an interval was started
+	Time     time.Time
+	Duration time.Duration
+	Missed   int
+	Err      error
+}
+
+// Events returns a channel the Runner sends an Event on for each thing
+// that happens in it. Events are only sent once Events has been called.
+// The channel is buffered, and events that don't fit because the
+// consumer is slow are dropped rather than holding up the Runner. The
+// number dropped is returned by DroppedEvents. The channel is never
+// closed.
+func (r *Runner) Events() <-chan Event {
+	r.eventsOn.Store(true)
+	return r.events
+}
+
+// DroppedEvents returns how many events have been dropped because the
+// channel returned by Events was full
+func (r *Runner) DroppedEvents() uint64 {
+	return r.eventsDropped.Load()
+}
+
+// emit sends e on the events channel if it is being consumed, dropping
+// it if the channel is full
+func (r *Runner) emit(e Event) {
+	if !r.eventsOn.Load() {
+		return
+	}
+	select {
+	case r.events <- e:
+	default:
+		r.eventsDropped.Add(1)
+	}
+}
+
+// eventHooks sets the Runner's hooks to emit events, before any set by
+// options so events are sent first
+func (r *Runner) eventHooks() {
+	r.onJobStart = append(r.onJobStart, func(name string, t time.Time) {
+		r.emit(Event{Kind: EventJobStarted, Job: name, Time: t})
+	})
+	r.onJobComplete = append(r.onJobComplete, func(name string, t time.Time, dur time.Duration, err error) {
+		r.emit(Event{Kind: EventJobCompleted, Job: name, Time: t, Duration: dur, Err: err})
+	})
+	r.onCatchup = append(r.onCatchup, func(missed int, behind time.Duration) {
+		r.emit(Event{Kind: EventCatchup, Missed: missed, Duration: behind})
+	})
+}
@@ -0,0 +1,31 @@
+package ensureinterval
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SetFrequency changes the Frequency of the named job while the Runner
+// is running, taking effect from the next interval. The job must be in
+// the set last loaded by the Runner, and the new frequency is used in
+// place of the job's own, which is left unchanged. An error matching
+// ErrInvalidFrequency is returned if freq isn't valid for the interval,
+// the same as when the jobs are loaded, or if the job has a Period or
+// Cron set, which it would be ignored for.
+func (r *Runner) SetFrequency(name string, freq time.Duration) error {
+	job, interval, err := r.findJob(name)
+	if err != nil {
+		return err
+	}
+	if job.Period > 0 || job.Cron != "" {
+		return errors.Wrapf(ErrInvalidFrequency, "job %s has a Period or Cron, so doesn't use a Frequency", name)
+	}
+	if err := checkFrequency(name, freq, interval); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.frequencies[name] = freq
+	return nil
+}
@@ -0,0 +1,82 @@
+package ensureinterval
+
+import (
+	"github.com/pkg/errors"
+)
+
+// TriggerGroup runs every job in the group from the set last loaded by
+// the Runner now, regardless of their schedule, the same as
+// TriggerAll. An error matching ErrUnknownGroup is returned if no
+// loaded job is in the group.
+func (r *Runner) TriggerGroup(group string) error {
+	if !r.hasGroup(group) {
+		return errors.Wrapf(ErrUnknownGroup, "group %s", group)
+	}
+	return r.triggerJobs(func(j *Job) bool {
+		return j.Group == group
+	})
+}
+
+// DisableGroup stops every job in the group from being run until the
+// group is enabled again with EnableGroup. It applies to jobs loaded
+// later too.
+func (r *Runner) DisableGroup(group string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.disabledGroups[group] = true
+}
+
+// EnableGroup enables the jobs in a group disabled with DisableGroup.
+// Jobs in the group disabled by other means stay disabled.
+func (r *Runner) EnableGroup(group string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.disabledGroups, group)
+}
+
+// GroupStats is the same as Stats, but only returns the stats of the
+// jobs in the group from the set last loaded by the Runner.
+func (r *Runner) GroupStats(group string) map[string]JobStat {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := map[string]JobStat{}
+	for _, j := range r.jobs {
+		if stat, ok := r.stats[j.Name]; ok && j.Group == group {
+			stats[j.Name] = *stat
+		}
+	}
+	return stats
+}
+
+// hasGroup returns true if a job in the set last loaded is in group
+func (r *Runner) hasGroup(group string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, j := range r.jobs {
+		if j.Group == group {
+			return true
+		}
+	}
+	return false
+}
+
+// triggerJobs runs the jobs last loaded that match, skipping disabled
+// and done jobs and NoOverlap jobs that are already running
+func (r *Runner) triggerJobs(match func(*Job) bool) error {
+	r.mu.Lock()
+	jobs, interval := r.jobs, r.interval
+	r.mu.Unlock()
+	now := r.truncate(r.clock.Now(), interval)
+	run := []*Job{}
+	for _, j := range jobs {
+		if !match(j) || r.isDisabled(j) || r.isFinished(j.Name) {
+			continue
+		}
+		if !r.acquire(j) {
+			r.jobLogf(j, now, "skipping, previous run has not finished")
+			continue
+		}
+		run = append(run, j)
+	}
+	return r.runJobs(r.baseContext(), now, interval, run)
+}
@@ -0,0 +1,66 @@
+package ensureinterval
+
+import (
+	"time"
+)
+
+// LastTick returns when the Runner last started an interval, or the
+// zero time if it hasn't yet. It is safe to call while the Runner is
+// running.
+func (r *Runner) LastTick() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastTick
+}
+
+// Healthy returns true if the Runner is running and has started an
+// interval within maxStale, so it can back a liveness probe. maxStale
+// should be longer than the interval plus the time the jobs take.
+func (r *Runner) Healthy(maxStale time.Duration) bool {
+	now := r.clock.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.active && !r.lastTick.IsZero() && now.Sub(r.lastTick) <= maxStale
+}
+
+// Lag returns how long after its boundary the Runner started the last
+// interval, which grows when the Runner is overloaded. It is safe to
+// call while the Runner is running.
+func (r *Runner) Lag() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lag
+}
+
+// CatchupCount returns how many missed intervals the Runner has caught
+// up since it was created, including those missed while it was stopped
+// when it has a StateStore. A count that keeps rising means the Runner
+// is always behind, even if it never reaches the max catchups.
+func (r *Runner) CatchupCount() int {
+	return int(r.catchups.Load())
+}
+
+// Sleeps returns how many times the Runner has gone to sleep until its
+// next interval. With a fake clock, a rise means the Runner has
+// finished an interval and is waiting for the clock, which is how
+// ensureintervaltest.RunFor knows when to advance it.
+func (r *Runner) Sleeps() uint64 {
+	return r.sleeps.Load()
+}
+
+// tick records that the Runner started the interval at boundary at t
+func (r *Runner) tick(t, boundary time.Time) {
+	r.mu.Lock()
+	r.lastTick = t
+	r.lag = t.Sub(boundary)
+	r.mu.Unlock()
+	r.emit(Event{Kind: EventTick, Time: t})
+}
+
+// tickComplete calls the tick complete hooks for the interval at
+// boundary, elapsed after it
+func (r *Runner) tickComplete(boundary time.Time, elapsed, interval time.Duration) {
+	for _, f := range r.onTick {
+		f(boundary, elapsed, elapsed > interval)
+	}
+}
@@ -0,0 +1,128 @@
+package ensureinterval
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// jobStatus is a job as listed by the Handler
+type jobStatus struct {
+	Name                string     `json:"name"`
+	Group               string     `json:"group,omitempty"`
+	Disabled            bool       `json:"disabled"`
+	NextRun             *time.Time `json:"next_run,omitempty"`
+	LastRun             *time.Time `json:"last_run,omitempty"`
+	LastDuration        string     `json:"last_duration,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	Runs                int        `json:"runs"`
+	Failures            int        `json:"failures"`
+	Skipped             int        `json:"skipped"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+}
+
+// Handler returns an http.Handler to inspect and control the Runner,
+// serving JSON at these routes:
+//
+//	GET  /jobs                 lists the jobs last loaded and their stats
+//	POST /jobs/{name}/trigger  runs the job now, the same as Trigger
+//	POST /jobs/{name}/disable  disables the job, the same as DisableJob
+//	POST /jobs/{name}/enable   enables the job, the same as EnableJob
+//
+// Use http.StripPrefix to serve it under a path.
+func (r *Runner) Handler() http.Handler {
+	return http.HandlerFunc(r.serveHTTP)
+}
+
+func (r *Runner) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	path := strings.Trim(req.URL.Path, "/")
+	if path == "jobs" {
+		if req.Method != http.MethodGet {
+			writeJSONError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		writeJSON(w, http.StatusOK, r.jobStatuses())
+		return
+	}
+	i := strings.LastIndex(path, "/")
+	if !strings.HasPrefix(path, "jobs/") || i <= len("jobs/") {
+		writeJSONError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	if req.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	name, action := path[len("jobs/"):i], path[i+1:]
+	if _, _, err := r.findJob(name); err != nil {
+		writeJSONError(w, http.StatusNotFound, err)
+		return
+	}
+	switch action {
+	case "trigger":
+		if err := r.Trigger(name); errors.Is(err, ErrJobRunning) || errors.Is(err, ErrJobDisabled) || errors.Is(err, ErrJobDone) {
+			writeJSONError(w, http.StatusConflict, err)
+			return
+		} else if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+	case "disable":
+		r.DisableJob(name)
+	case "enable":
+		r.EnableJob(name)
+	default:
+		writeJSONError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// jobStatuses returns the status of each job last loaded, by name
+func (r *Runner) jobStatuses() []jobStatus {
+	r.mu.Lock()
+	jobs := r.jobs
+	r.mu.Unlock()
+	stats := r.Stats()
+	statuses := make([]jobStatus, 0, len(jobs))
+	for _, j := range jobs {
+		st := jobStatus{
+			Name:     j.Name,
+			Group:    j.Group,
+			Disabled: r.isDisabled(j),
+		}
+		if next, err := r.NextRun(j.Name); err == nil && !next.IsZero() {
+			st.NextRun = &next
+		}
+		if stat, ok := stats[j.Name]; ok {
+			st.LastRun = &stat.LastRun
+			st.LastDuration = stat.LastDuration.String()
+			if stat.LastError != nil {
+				st.LastError = stat.LastError.Error()
+			}
+			st.Runs = stat.Runs
+			st.Failures = stat.Failures
+			st.Skipped = stat.Skipped
+			st.ConsecutiveFailures = stat.ConsecutiveFailures
+		}
+		statuses = append(statuses, st)
+	}
+	sort.Slice(statuses, func(a, b int) bool {
+		return statuses[a].Name < statuses[b].Name
+	})
+	return statuses
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -1,31 +1,196 @@
 package ensureinterval // import "github.com/dangersalad/go-ensureinterval"
 
 import (
+	"context"
 	"fmt"
 	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+	"math"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
 // Job is a job to run at a specified interval
 type Job struct {
-	Name      string
-	Exec      ExecFunc
+	// Name identifies the job in logs and stats. If empty when the job
+	// is loaded, it is set to the name of the Exec function, or to
+	// "job-N" (N being the job's index) if that isn't usable.
+	Name string
+	Exec ExecFunc
+	// Result can be set instead of Exec for a job that returns a
+	// result, which is kept as the LastResult in the Runner's Stats.
+	Result ResultFunc
+	// Frequency is how many intervals to wait between runs of the job,
+	// so a Frequency of 5 runs the job every 5th interval. Zero runs the
+	// job every interval, the same as 1. It is a count rather than a
+	// duration, so a Frequency that is a multiple of the interval, such
+	// as time.Hour, is rejected as a mistake; use Period for that.
 	Frequency time.Duration
+	// Period is how often to run the job as a real duration, and if set
+	// is used instead of Frequency. It must be a multiple of the
+	// interval the job is run with.
+	Period time.Duration
+	// Cron is a standard five field cron expression, such as
+	// "0 9 * * MON-FRI", and if set is used instead of Frequency and
+	// Period. The job runs for each interval the expression fires in, so
+	// the interval should be no more than a minute to run the job at the
+	// times given. It can start with CRON_TZ= to set the time zone it is
+	// in, otherwise the Runner's location is used.
+	Cron string
+	// Timeout is how long the job may run before its context is
+	// cancelled and it is reported as failed with ErrJobTimeout. Zero
+	// means the Runner's WithJobTimeout is used, if it has one.
+	Timeout time.Duration
+	// MaxRetries is how many more times Exec is called within the same
+	// interval if it returns an error. The Timeout covers all attempts.
+	MaxRetries int
+	// RetryBackoff is the delay before the first retry, doubling for
+	// each retry after that up to RetryBackoffMax (if set).
+	RetryBackoff    time.Duration
+	RetryBackoffMax time.Duration
+	// NoOverlap skips a scheduled run of the job if its previous run
+	// (for example one left running after a Timeout) hasn't finished.
+	NoOverlap bool
+	// Disabled stops the job from being run without removing it
+	Disabled bool
+	// StartAfter is how long the Runner must have been running before
+	// the job is first run.
+	StartAfter time.Duration
+	// ExpiresAt is the time after which the job is no longer run. The
+	// zero value means the job never expires.
+	ExpiresAt time.Time
+	// NoCatchup stops the job being run when the Runner is catching up
+	// missed intervals, so it only runs for the current interval. When
+	// catchup is disabled for the Runner with WithCatchup this has no
+	// effect, as no job is caught up.
+	NoCatchup bool
+	// MaxConsecutiveFailures is how many runs in a row may fail before
+	// the Runner disables the job. It can be enabled again with
+	// Runner.EnableJob. Zero means the job is never disabled.
+	MaxConsecutiveFailures int
+	// FailureBackoff is how long to hold off running the job after it
+	// fails, doubling with each failure in a row up to
+	// FailureBackoffMax (if set). Once the backoff has passed the job
+	// runs on its normal schedule, and a successful run resets it.
+	FailureBackoff    time.Duration
+	FailureBackoffMax time.Duration
+	// Priority orders the jobs due in the same interval, highest
+	// first. Sequential jobs run in that order, concurrent jobs are only
+	// started in that order and may finish in any order. Jobs with the
+	// same priority keep the order they were loaded in.
+	Priority int
+	// DependsOn names jobs that must finish successfully before this
+	// job starts, when they are due in the same interval. If one of
+	// them fails the job is skipped for that interval. The jobs named
+	// must be loaded too, and dependencies can't form a cycle.
+	DependsOn []string
+	// Group puts the job in a group, so the jobs in it can be triggered
+	// or disabled together.
+	Group string
+	// CoalesceCatchup runs the job at most once when the Runner catches
+	// up missed intervals, for the last of them it was due in, rather
+	// than once for each. It is for jobs that only need the latest
+	// state, such as refreshing a cache.
+	CoalesceCatchup bool
+	// Idempotent records each interval the job runs for successfully in
+	// the Runner's StateStore, if it is a BucketStore, so an interval
+	// that was already run isn't run again when catching up, even after
+	// a restart. It is for jobs that write results keyed by the
+	// interval, which must not be written twice.
+	Idempotent bool
+
+	// namedFor is the function a job without a Name is named after, if
+	// not its Exec or Result
+	namedFor interface{}
+}
+
+// due returns true if the job is due to run for now
+func (r *Runner) due(j *Job, now time.Time, interval time.Duration) bool {
+	if j.Cron != "" {
+		return r.cronDue(j, now, interval)
+	}
+	return r.truncate(now, r.period(j, interval)).Equal(now)
+}
+
+// period returns how often the job runs with the given interval, with
+// the frequency given to SetFrequency in place of the job's own. Jobs
+// with a Cron expression have no fixed period, so for them it is the
+// interval.
+func (r *Runner) period(j *Job, interval time.Duration) time.Duration {
+	if j.Cron != "" {
+		return interval
+	}
+	if j.Period > 0 {
+		return j.Period
+	}
+	r.mu.Lock()
+	freq, ok := r.frequencies[j.Name]
+	r.mu.Unlock()
+	if !ok {
+		freq = j.Frequency
+	}
+	if freq <= 0 {
+		return interval
+	}
+	return freq * interval
+}
+
+// defaultJobName returns a name for a job without one, from its Exec
+// function or its index if that doesn't give a name not in taken
+func defaultJobName(j *Job, i int, taken map[string]bool) string {
+	var f interface{} = j.Exec
+	if j.namedFor != nil {
+		f = j.namedFor
+	} else if j.Result != nil {
+		f = j.Result
+	}
+	if !reflect.ValueOf(f).IsNil() {
+		if fn := runtime.FuncForPC(reflect.ValueOf(f).Pointer()); fn != nil {
+			if name := fn.Name(); name != "" && !taken[name] {
+				return name
+			}
+		}
+	}
+	return fmt.Sprintf("job-%d", i)
+}
+
+// expired returns true if the job should no longer be run at now
+func (j *Job) expired(now time.Time) bool {
+	return !j.ExpiresAt.IsZero() && now.After(j.ExpiresAt)
 }
 
 // JobLoader is a function to load in jobs before run
 type JobLoader func() ([]*Job, error)
 
-// ExecFunc is a function to be run at intervals by Run
-type ExecFunc func() error
+// staticJobs returns a JobLoader that always returns jobs
+func staticJobs(jobs []*Job) JobLoader {
+	return func() ([]*Job, error) {
+		return jobs, nil
+	}
+}
+
+// ExecFunc is a function to be run at intervals by Run. The context
+// passed in is cancelled when the context given to RunContext or
+// WithContext is, when StopWithTimeout is called or when the job's
+// timeout passes. Stop doesn't cancel it, it lets running jobs finish.
+type ExecFunc func(ctx context.Context) error
+
+// Middleware wraps the ExecFunc of a job with extra behaviour, calling
+// next to run the job.
+type Middleware func(next ExecFunc, job *Job) ExecFunc
 
-// the default max catchups to allow
-var maxKetchups = 20
+// ResultFunc is the same as an ExecFunc, but returns a result as well
+type ResultFunc func(ctx context.Context) (interface{}, error)
 
 // SetMaxCatchup sets the max intervals this is allowed to try to
-// catch up. The default is 20.
+// catch up. The default is 20. It is safe to call while running, but
+// WithMaxCatchup on a Runner should be preferred.
 func SetMaxCatchup(max int) {
-	maxKetchups = max
+	defaultRunner.maxCatchups.Store(int64(max))
 }
 
 // Run will run the Jobs provided at the specified interval,
@@ -33,92 +198,891 @@ func SetMaxCatchup(max int) {
 // provided Jobs takes longer than the interval provided.
 //
 // If the catchup attempts reach the value set by SetMaxInterval
-// (default 20) then it will return en error.
+// (default 20) then it will return an error matching ErrMaxCatchups.
+//
+// Time taken by jobs is measured with the monotonic clock, so setting
+// the system clock while jobs run doesn't cause catchups or long
+// sleeps. Each interval is scheduled from the wall clock though, so if
+// the clock is set backward the intervals in the repeated time are run
+// again, and if it is set forward the skipped intervals are not caught
+// up.
 //
 // An error will also be returned if the exec function returns an
-// error. In this case you will need to restart the runner manually.
+// error. In this case you will need to restart the runner manually,
+// unless the Runner was created with WithContinueOnError.
 func Run(interval time.Duration, getJobs JobLoader) error {
-	for {
-		now := time.Now().Truncate(interval)
-		jobs, err := getJobs()
-		if err != nil {
-			return errors.Wrap(err, "getting jobs")
-		}
-		if err := processJobs(now, interval, jobs); err != nil {
+	return defaultRunner.Run(interval, getJobs)
+}
+
+// RunContext is the same as Run, but will stop and return ctx.Err()
+// once the provided context is cancelled. The context is checked
+// before each interval and before each catchup run, and the sleep
+// between intervals is cut short on cancellation.
+func RunContext(ctx context.Context, interval time.Duration, getJobs JobLoader) error {
+	return defaultRunner.RunContext(ctx, interval, getJobs)
+}
+
+// RunJobs is the same as Run, but runs a fixed set of jobs instead of
+// loading them every interval.
+func RunJobs(interval time.Duration, jobs []*Job) error {
+	return defaultRunner.RunJobs(interval, jobs)
+}
+
+// RunN runs n intervals of Run, including any catchups, and returns nil
+// once they are done. It returns errors the same way Run does.
+func RunN(n int, interval time.Duration, getJobs JobLoader) error {
+	return defaultRunner.RunN(n, interval, getJobs)
+}
+
+// RunOnce runs the Jobs that are due at the current interval a single
+// time and returns the aggregated error, without catching up or
+// sleeping. This is useful when the process itself is scheduled
+// externally, such as with a cron job.
+func RunOnce(interval time.Duration, getJobs JobLoader) error {
+	return defaultRunner.RunOnce(interval, getJobs)
+}
+
+// RunOnce runs the Jobs that are due at the current interval a single
+// time using the settings of the Runner. See the package level RunOnce
+// for details.
+func (r *Runner) RunOnce(interval time.Duration, getJobs JobLoader) error {
+	if err := checkInterval(interval); err != nil {
+		return err
+	}
+	now := r.truncate(r.clock.Now(), interval)
+	jobs, err := r.loadJobs(interval, getJobs)
+	if err != nil {
+		return err
+	}
+	return r.processJobs(r.baseContext(), now, interval, jobs, runScheduled)
+}
+
+// Run will run the Jobs provided at the specified interval using the
+// settings of the Runner. See the package level Run for details. If
+// getJobs is nil, the jobs added with AddJob are run.
+func (r *Runner) Run(interval time.Duration, getJobs JobLoader) error {
+	return r.RunContext(context.Background(), interval, getJobs)
+}
+
+// RunJobs is the same as Run, but runs a fixed set of jobs instead of
+// loading them every interval.
+func (r *Runner) RunJobs(interval time.Duration, jobs []*Job) error {
+	return r.Run(interval, staticJobs(jobs))
+}
+
+// RunContext is the same as Run, but will stop and return ctx.Err()
+// once the provided context is cancelled.
+func (r *Runner) RunContext(ctx context.Context, interval time.Duration, getJobs JobLoader) error {
+	return r.run(ctx, interval, getJobs, -1, false)
+}
+
+// RunN runs n intervals, including any catchups, using the settings of
+// the Runner and returns nil once they are done.
+func (r *Runner) RunN(n int, interval time.Duration, getJobs JobLoader) error {
+	if n <= 0 {
+		return nil
+	}
+	return r.run(context.Background(), interval, getJobs, n, false)
+}
+
+// run runs the main loop for n intervals, or forever if n is negative.
+// If drain is true and ctx is cancelled, the running jobs are waited
+// on before the Runner is done rather than abandoned.
+func (r *Runner) run(ctx context.Context, interval time.Duration, getJobs JobLoader, n int, drain bool) error {
+	if err := checkInterval(interval); err != nil {
+		return err
+	}
+	ctx, release := r.bindContext(ctx)
+	defer release()
+	r.setStarted()
+	defer func() {
+		r.exit(drain && ctx.Err() != nil)
+	}()
+	if ok, err := r.waitAlignStart(ctx, interval); !ok {
+		return contextErr(ctx, err)
+	}
+	return contextErr(ctx, r.loop(ctx, interval, getJobs, n, true))
+}
+
+// waitAlignStart waits for the next interval boundary if the Runner
+// is set to align its start. It returns false if the Runner was
+// stopped or ctx cancelled while waiting, along with ctx.Err().
+func (r *Runner) waitAlignStart(ctx context.Context, interval time.Duration) (bool, error) {
+	if !r.alignStart {
+		return true, nil
+	}
+	now := r.clock.Now()
+	wait, stop := r.after(r.nextBoundary(now, interval).Sub(now))
+	defer stop()
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case <-r.stop:
+		return false, nil
+	case <-wait:
+		return true, nil
+	}
+}
+
+// loop runs the intervals for run, with start set if this is the
+// first time the Runner is looping
+func (r *Runner) loop(ctx context.Context, interval time.Duration, getJobs JobLoader, n int, start bool) error {
+	for i := 0; n < 0 || i < n; i++ {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
-		lastElapsed := time.Now().Sub(now)
-		for elapsed, totalInterval := lastElapsed, interval; elapsed > totalInterval; elapsed, totalInterval = elapsed+lastElapsed, totalInterval+interval {
-			nowKetchup := now.Add(totalInterval)
-			if err := processJobs(nowKetchup, interval, jobs); err != nil {
+		if r.stopped() {
+			return nil
+		}
+		if i == 0 && start {
+			if err := r.startupCheck(ctx, interval, getJobs); err != nil {
 				return err
 			}
-			lastElapsed = time.Now().Sub(nowKetchup)
-			if totalInterval > interval*time.Duration(maxKetchups) {
-				return &errMaxCatchups{}
+			if err := r.resume(ctx, interval, getJobs); err != nil {
+				return err
 			}
 		}
+		kind := runScheduled
+		if i == 0 && start && r.runOnStart {
+			kind = runStart
+		}
+		lastElapsed, err := r.runInterval(ctx, interval, getJobs, kind)
+		if err != nil {
+			return err
+		}
+		if i == n-1 {
+			break
+		}
 		sleepTime := interval - lastElapsed
-		time.Sleep(sleepTime)
+		if r.location != nil {
+			// intervals vary in length across DST changes, so go by
+			// the next boundary
+			now := r.clock.Now()
+			sleepTime = r.nextBoundary(now, interval).Sub(now)
+		}
+		if sleepTime < r.minSleep {
+			sleepTime = r.minSleep
+		}
+		sleepTime += r.jitter()
+		wait, stop := r.after(sleepTime)
+		r.sleeps.Add(1)
+		select {
+		case <-ctx.Done():
+			stop()
+			return ctx.Err()
+		case <-r.stop:
+			stop()
+			return nil
+		case <-wait:
+		}
 	}
+	return nil
 }
 
-func runJob(job *Job, interval time.Duration, complete chan error) {
-	debugf("running job %s (%s)", job.Name, job.Frequency*interval)
-	err := job.Exec()
+// runInterval processes the jobs for the current interval and catches
+// up any intervals missed while doing so. It returns how long the last
+// processing took.
+func (r *Runner) runInterval(ctx context.Context, interval time.Duration, getJobs JobLoader, kind runKind) (time.Duration, error) {
+	start := r.clock.Now()
+	now := r.truncate(start, interval)
+	r.tick(start, now)
+	// Truncate drops the monotonic reading, so measure from start to
+	// keep a wall clock change while jobs run from skewing the elapsed
+	// time
+	since := func(t time.Time) time.Duration {
+		return r.clock.Since(start) + start.Sub(t)
+	}
+	jobs, err := r.loadJobs(interval, getJobs)
 	if err != nil {
-		// signal complete with error
-		complete <- errors.Wrapf(err, "executing job %s", job.Name)
+		return 0, err
+	}
+	if err := r.checkNoJobs(now, jobs); err != nil {
+		return 0, err
+	}
+	if err := r.jobsError(ctx, r.processJobs(ctx, now, interval, jobs, kind)); err != nil {
+		return 0, err
+	}
+	lastElapsed := since(now)
+	if !r.catchup {
+		if lastElapsed > interval {
+			r.debugf("skipping %d missed intervals", lastElapsed/interval)
+		}
+		r.tickComplete(now, lastElapsed, interval)
+		// sleep until the next boundary rather than catching up
+		return lastElapsed % interval, nil
+	}
+	if lastElapsed > interval {
+		for _, f := range r.onCatchup {
+			f(int(lastElapsed/interval), lastElapsed-interval)
+		}
+	}
+	if r.concurrentCatchup > 1 {
+		lastElapsed, err = r.catchupConcurrently(ctx, now, interval, jobs, since)
+	} else {
+		lastElapsed, err = r.catchupSequentially(ctx, now, lastElapsed, interval, jobs, since)
+	}
+	if err != nil {
+		return 0, err
+	}
+	// run the jobs that coalesce their catchups, for the last interval
+	// they missed
+	coalesceStart := r.clock.Now()
+	if err := r.runCoalesced(ctx, interval); err != nil {
+		return 0, err
+	}
+	r.tickComplete(now, since(now), interval)
+	return lastElapsed + r.clock.Since(coalesceStart), nil
+}
+
+// catchupSequentially catches up the intervals missed since now for
+// Run, one after another, given how long it has been since now. It
+// returns how long it has been since the last interval caught up.
+func (r *Runner) catchupSequentially(ctx context.Context, now time.Time, lastElapsed, interval time.Duration, jobs []*Job, since func(time.Time) time.Duration) (time.Duration, error) {
+	nowKetchup := now
+	for elapsed, totalInterval := lastElapsed, interval; elapsed > totalInterval; elapsed, totalInterval = elapsed+lastElapsed, totalInterval+interval {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		if r.stopped() {
+			break
+		}
+		nowKetchup = r.nextBoundary(nowKetchup, interval)
+		if r.staleCatchup(nowKetchup) {
+			r.logf("skipping stale catchup for %s", nowKetchup)
+		} else if err := r.jobsError(ctx, r.processJobs(ctx, nowKetchup, interval, jobs, runCatchup)); err != nil {
+			return 0, err
+		}
+		lastElapsed = since(nowKetchup)
+		if totalInterval > interval*time.Duration(r.maxCatchups.Load()) {
+			if err := r.catchupExceeded(int(totalInterval/interval), lastElapsed); err != nil {
+				return 0, err
+			}
+			return since(now) % interval, nil
+		}
+	}
+	return lastElapsed, nil
+}
+
+// staleCatchup returns true if the catchup for now is older than the
+// max catchup age
+func (r *Runner) staleCatchup(now time.Time) bool {
+	return r.maxCatchupAge > 0 && r.clock.Now().Sub(now) > r.maxCatchupAge
+}
+
+// checkInterval returns an error if interval can't be run with
+func checkInterval(interval time.Duration) error {
+	if interval <= 0 {
+		return errors.Wrapf(ErrInvalidInterval, "interval %s", interval)
 	}
-	// signal complete without error
-	complete <- nil
+	return nil
 }
 
-func processJobs(now time.Time, interval time.Duration, jobs []*Job) error {
-	completes := []chan error{}
-	jCount := 0
+// checkFrequency returns an error matching ErrInvalidFrequency if freq
+// isn't a valid Frequency for the named job to run with the interval
+func checkFrequency(name string, freq, interval time.Duration) error {
+	if freq < 0 {
+		return errors.Wrapf(ErrInvalidFrequency, "job %s frequency %d", name, freq)
+	}
+	if interval > 1 && freq >= interval && freq%interval == 0 {
+		return errors.Wrapf(ErrInvalidFrequency, "job %s frequency %d is a multiple of the interval %s, Frequency is a number of intervals, use Period for a duration", name, freq, interval)
+	}
+	if freq > math.MaxInt64/interval {
+		return errors.Wrapf(ErrInvalidFrequency, "job %s frequency %d is too large for the interval %s", name, freq, interval)
+	}
+	return nil
+}
+
+// cachedJobs returns the jobs last loaded if the Runner has a loader
+// interval and it hasn't passed since they were loaded
+func (r *Runner) cachedJobs(interval time.Duration) ([]*Job, bool) {
+	if r.loaderInterval <= 0 {
+		return nil, false
+	}
+	now := r.truncate(r.clock.Now(), interval)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.loadedAt.IsZero() || r.interval != interval || now.Sub(r.loadedAt) >= r.loaderInterval {
+		return nil, false
+	}
+	return r.jobs, true
+}
+
+// loadJobs gets the jobs from the loader and checks they are valid to
+// run with the interval
+func (r *Runner) loadJobs(interval time.Duration, getJobs JobLoader) ([]*Job, error) {
+	if jobs, ok := r.cachedJobs(interval); ok {
+		return jobs, nil
+	}
+	if getJobs == nil {
+		getJobs = r.registeredJobs
+	}
+	r.mu.Lock()
+	r.loader = getJobs
+	r.mu.Unlock()
+	jobs, err := r.fetchJobs(interval, getJobs)
+	r.mu.Lock()
+	if err == nil {
+		r.loadFailures = 0
+		r.mu.Unlock()
+		return jobs, nil
+	}
+	r.loadFailures++
+	failures, last := r.loadFailures, r.jobs
+	reuse := failures <= r.maxLoadFailures && !r.loadedAt.IsZero() && r.interval == interval
+	r.mu.Unlock()
+	if !reuse {
+		return nil, err
+	}
+	r.logf("%s, reusing the jobs last loaded (%d failures in a row)", err, failures)
+	return last, nil
+}
+
+// fetchJobs calls the loader and checks the jobs are valid to run
+// with the interval
+func (r *Runner) fetchJobs(interval time.Duration, getJobs JobLoader) ([]*Job, error) {
+	jobs, err := getJobs()
+	if err != nil {
+		return nil, errors.Wrap(err, "getting jobs")
+	}
+	names := map[string]bool{}
+	for i, j := range jobs {
+		if j.Name == "" {
+			j.Name = defaultJobName(j, i, names)
+		}
+		if names[j.Name] {
+			return nil, errors.Wrapf(ErrDuplicateJobName, "job %s", j.Name)
+		}
+		names[j.Name] = true
+		if err := checkFrequency(j.Name, j.Frequency, interval); err != nil {
+			return nil, err
+		}
+		if j.Period != 0 && (j.Period < 0 || j.Period%interval != 0) {
+			return nil, errors.Wrapf(ErrInvalidPeriod, "job %s period %s with interval %s", j.Name, j.Period, interval)
+		}
+		if j.Cron != "" {
+			if _, err := r.cronSchedule(j.Cron); err != nil {
+				return nil, errors.Wrapf(err, "job %s", j.Name)
+			}
+		}
+	}
+	if err := checkDependencies(jobs); err != nil {
+		return nil, err
+	}
+	r.setJobs(interval, jobs)
+	return jobs, nil
+}
+
+// checkNoJobs returns ErrNoJobs if the Runner is set to error when
+// there are no jobs left to run and every job has expired or is done.
+// Otherwise it warns the first time in a row no jobs were loaded.
+func (r *Runner) checkNoJobs(now time.Time, jobs []*Job) error {
+	r.mu.Lock()
+	warn := len(jobs) == 0 && !r.noJobs
+	r.noJobs = len(jobs) == 0
+	r.mu.Unlock()
+	if !r.errorOnNoJobs {
+		if warn {
+			r.logf("no jobs were loaded, nothing will run until there are some")
+		}
+		return nil
+	}
 	for _, j := range jobs {
-		if now.Truncate(j.Frequency*interval) == now {
-			complete := make(chan error)
-			completes = append(completes, complete)
-			jCount++
-			go runJob(j, interval, complete)
+		if !j.expired(now) && !r.isFinished(j.Name) {
+			return nil
 		}
 	}
-	debugf("started %d jobs", jCount)
-	errs := []error{}
-	for _, c := range completes {
-		err := <-c
-		if err != nil {
-			logf("%+v", err)
-			errs = append(errs, err)
+	return ErrNoJobs
+}
+
+// logExpired logs that a job has expired, the first time it is seen
+func (r *Runner) logExpired(job *Job, now time.Time) {
+	r.mu.Lock()
+	seen := r.expired[job.Name]
+	r.expired[job.Name] = true
+	r.mu.Unlock()
+	if !seen {
+		r.jobLogf(job, now, "expired at %s", job.ExpiresAt)
+	}
+}
+
+// jobsError returns the error from processJobs if it should stop the
+// Runner. If ctx was cancelled while the jobs ran, their errors are
+// from being cancelled, so ctx.Err() is returned instead.
+func (r *Runner) jobsError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	r.emit(Event{Kind: EventError, Err: err})
+	if r.errorHandler != nil {
+		return r.errorHandler(err)
+	}
+	if r.continueOnError {
+		return nil
+	}
+	return err
+}
+
+func (r *Runner) runJob(ctx context.Context, job *Job, now time.Time, interval time.Duration) error {
+	if err := r.waitRateLimit(ctx); err != nil {
+		r.release(job)
+		return errors.Wrapf(err, "waiting to run job %s", job.Name)
+	}
+	ctx = withRunInfo(ctx, RunInfo{ID: r.runID(), Job: job.Name, ScheduledFor: now, Interval: interval})
+	r.runDebugf(ctx, job, "running for %s (every %s)", now, r.period(job, interval))
+	for _, f := range r.onJobStart {
+		f(job.Name, now)
+	}
+	start := r.clock.Now()
+	err := r.execJobTimeout(ctx, job)
+	done := errors.Is(err, ErrJobDone)
+	skipped := errors.Is(err, ErrSkipped)
+	var next *ErrBackoff
+	adapt := errors.As(err, &next)
+	if done || skipped || adapt {
+		err = nil
+	}
+	if err != nil {
+		r.runErrorf(ctx, job, "%+v", err)
+		err = errors.Wrapf(err, "executing job %s", job.Name)
+	} else if skipped {
+		r.runDebugf(ctx, job, "skipped")
+	} else {
+		r.runDebugf(ctx, job, "finished")
+	}
+	dur := r.clock.Since(start)
+	if period := r.period(job, interval); job.Cron == "" && dur > period {
+		r.runLogf(ctx, job, "took %s, longer than its period of %s", dur, period)
+		for _, f := range r.onSlowJob {
+			f(job.Name, dur, period)
+		}
+	}
+	if skipped {
+		r.recordSkip(job.Name)
+	} else {
+		failures := r.recordStat(job.Name, start, dur, err)
+		if err == nil {
+			r.recordRun(job.Name, now)
+			if job.Idempotent {
+				r.recordBucket(job.Name, now, interval)
+			}
 		}
-		debug("job finished")
+		if job.MaxConsecutiveFailures > 0 && failures >= job.MaxConsecutiveFailures {
+			r.trip(job, now, err)
+		}
+		r.setBackoff(job, now, failures)
+	}
+	if adapt {
+		r.holdOff(ctx, job, now, next.Next, interval)
 	}
+	for _, f := range r.onJobComplete {
+		f(job.Name, now, dur, err)
+	}
+	if done {
+		r.finish(job, now)
+	}
+	return err
+}
 
-	// if none of the jobs returned an errors, just proceed
-	if len(errs) == 0 {
+// waitRateLimit waits until the Runner's rate limit allows another job
+// to start, returning ctx.Err() early if ctx is cancelled
+func (r *Runner) waitRateLimit(ctx context.Context) error {
+	if r.limiter == nil {
 		return nil
 	}
+	now := r.clock.Now()
+	res := r.limiter.ReserveN(now, 1)
+	if err := r.sleep(ctx, res.DelayFrom(now)); err != nil {
+		res.CancelAt(r.clock.Now())
+		return err
+	}
+	return nil
+}
 
-	errStr := ""
-	for _, err := range errs {
-		errStr = fmt.Sprintf("%s, %s", errStr, err)
+// sleep waits for d on the Runner's clock, returning ctx.Err() early
+// if ctx is cancelled
+func (r *Runner) sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if d <= 0 {
+		return nil
+	}
+	wait, stop := r.after(d)
+	defer stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-wait:
+		return nil
 	}
-	return errors.New(errStr)
 }
 
-type errMaxCatchups struct {
+// execJob calls the job's Exec, turning a panic into an error
+func (r *Runner) execJob(ctx context.Context, job *Job) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			if r.panicHandler != nil {
+				r.panicHandler(job, rec)
+			}
+			err = errors.Errorf("panic: %v\n%s", rec, debug.Stack())
+		}
+	}()
+	exec := job.Exec
+	if job.Result != nil {
+		exec = func(ctx context.Context) error {
+			res, err := job.Result(ctx)
+			if err == nil && !isStartupCheck(ctx) {
+				r.recordResult(job.Name, res)
+			}
+			return err
+		}
+	}
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		exec = r.middleware[i](exec, job)
+	}
+	return exec(ctx)
+}
+
+// execJobTimeout runs execJob under the job's timeout, if it has one,
+// or the Runner's default timeout. If the timeout is reached the job is
+// left running with its context cancelled and an error matching
+// ErrJobTimeout is returned.
+func (r *Runner) execJobTimeout(ctx context.Context, job *Job) error {
+	d := job.Timeout
+	if d <= 0 {
+		d = r.jobTimeout
+	}
+	if d <= 0 {
+		defer r.release(job)
+		return r.execJobRetries(ctx, job)
+	}
+	// only the system clock can give the job a deadline, on other
+	// clocks its context is cancelled once the timeout passes below
+	var jobCtx context.Context
+	var cancel context.CancelFunc
+	if _, ok := r.clock.(realClock); ok {
+		jobCtx, cancel = context.WithTimeout(ctx, d)
+	} else {
+		jobCtx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+	timeout, stop := r.after(d)
+	defer stop()
+	done := make(chan error, 1)
+	go func() {
+		defer r.release(job)
+		done <- r.execJobRetries(jobCtx, job)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-timeout:
+		return errors.Wrapf(ErrJobTimeout, "after %s", d)
+	case <-jobCtx.Done():
+		if ctx.Err() == nil {
+			return errors.Wrapf(ErrJobTimeout, "after %s", d)
+		}
+		// the runner is stopping, let the job finish, but no later
+		// than its timeout
+		select {
+		case err := <-done:
+			return err
+		case <-timeout:
+			return errors.Wrapf(ErrJobTimeout, "after %s", d)
+		}
+	}
 }
 
-func (e *errMaxCatchups) Error() string {
-	return "max catchups reached"
+// setStarted records the time the Runner started running
+func (r *Runner) setStarted() {
+	now := r.clock.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.startedAt = now
+	r.active = true
+	if r.maxRuntime > 0 {
+		go r.stopAfter(r.maxRuntime)
+	}
 }
 
-func (e *errMaxCatchups) String() string {
-	return e.Error()
+// getStarted returns the time the Runner started running
+func (r *Runner) getStarted() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.startedAt
 }
 
-func (e *errMaxCatchups) Temporary() bool {
+// acquire marks a job as running, returning false if it is a
+// NoOverlap job that already is
+func (r *Runner) acquire(job *Job) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if job.NoOverlap {
+		if r.inFlight[job.Name] {
+			return false
+		}
+		r.inFlight[job.Name] = true
+	}
+	r.running++
+	r.runningJobs[job.Name]++
 	return true
 }
+
+// release marks a job as no longer running
+func (r *Runner) release(job *Job) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if job.NoOverlap {
+		delete(r.inFlight, job.Name)
+	}
+	if r.runningJobs[job.Name]--; r.runningJobs[job.Name] == 0 {
+		delete(r.runningJobs, job.Name)
+	}
+	if r.running--; r.running == 0 {
+		r.idle.Broadcast()
+	}
+}
+
+// execJobRetries runs execJob, retrying up to the job's MaxRetries
+func (r *Runner) execJobRetries(ctx context.Context, job *Job) error {
+	err := r.execJob(ctx, job)
+	attempts := 1
+	backoff := job.RetryBackoff
+	for ; retryable(err) && attempts <= job.MaxRetries; attempts++ {
+		if r.sleep(ctx, backoff) != nil {
+			break
+		}
+		if backoff *= 2; job.RetryBackoffMax > 0 && backoff > job.RetryBackoffMax {
+			backoff = job.RetryBackoffMax
+		}
+		r.runDebugf(ctx, job, "retrying (attempt %d): %s", attempts+1, err)
+		err = r.execJob(ctx, job)
+	}
+	if err != nil && attempts > 1 {
+		return errors.Wrapf(err, "after %d attempts", attempts)
+	}
+	return err
+}
+
+// retryable returns true if err is a failure that should be retried,
+// rather than nil or a signal from the job
+func retryable(err error) bool {
+	var next *ErrBackoff
+	return err != nil && !errors.Is(err, ErrJobDone) && !errors.Is(err, ErrSkipped) && !errors.As(err, &next)
+}
+
+// runKind is why processJobs is being called
+type runKind int
+
+const (
+	// runScheduled runs the jobs due in the current interval
+	runScheduled runKind = iota
+	// runCatchup runs the jobs due in an interval that was missed
+	runCatchup
+	// runStart runs every job, for WithRunOnStart
+	runStart
+	// runResume runs the jobs due in an interval missed while the
+	// Runner was stopped, for WithStateStore
+	runResume
+)
+
+// processJobs runs the jobs due at now for the kind of run
+func (r *Runner) processJobs(ctx context.Context, now time.Time, interval time.Duration, jobs []*Job, kind runKind) error {
+	started := r.getStarted()
+	if kind == runCatchup || kind == runResume {
+		r.catchups.Add(1)
+	}
+	due := []*Job{}
+	for _, j := range jobs {
+		if r.isDisabled(j) {
+			r.jobDebugf(j, now, "skipping, disabled")
+			continue
+		}
+		if r.isFinished(j.Name) {
+			continue
+		}
+		if j.expired(now) {
+			r.logExpired(j, now)
+			continue
+		}
+		if (kind == runCatchup || kind == runResume) && j.NoCatchup {
+			continue
+		}
+		if (kind == runCatchup || kind == runResume) && j.Idempotent && r.bucketDone(j.Name, now) {
+			r.jobDebugf(j, now, "skipping, already ran for %s", now)
+			continue
+		}
+		if last, ok := r.lastRun(j.Name); ok && !now.After(last) {
+			r.jobDebugf(j, now, "skipping, already ran for %s", last)
+			continue
+		} else if !ok && kind == runResume {
+			continue
+		}
+		if kind != runStart && !r.due(j, now, interval) {
+			continue
+		}
+		if notBefore := r.getBackoff(j.Name); now.Before(notBefore) {
+			r.jobDebugf(j, now, "skipping, backing off until %s", notBefore)
+			continue
+		}
+		if j.StartAfter > 0 && now.Before(started.Add(j.StartAfter)) {
+			r.jobDebugf(j, now, "skipping, not started yet")
+			continue
+		}
+		if j.CoalesceCatchup && (kind == runCatchup || kind == runResume) {
+			r.coalesce(j, now)
+			continue
+		}
+		if r.dryRun {
+			r.jobLogf(j, now, "would run for %s", now)
+			continue
+		}
+		if !r.acquire(j) {
+			r.jobLogf(j, now, "skipping, previous run has not finished")
+			continue
+		}
+		due = append(due, j)
+	}
+	return r.runJobs(ctx, now, interval, due)
+}
+
+// runJobs runs the jobs, which must already be acquired, in priority
+// and dependency order and returns a *JobErrors if any of them fail
+func (r *Runner) runJobs(ctx context.Context, now time.Time, interval time.Duration, jobs []*Job) error {
+	jobs = orderJobs(jobs)
+	r.debugf("started %d jobs", len(jobs))
+	errs := map[string]error{}
+	for i, err := range r.execJobs(ctx, now, interval, jobs) {
+		if err != nil {
+			errs[jobs[i].Name] = err
+		}
+	}
+
+	// if none of the jobs returned an errors, just proceed
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return &JobErrors{Errors: errs}
+}
+
+// execJobs runs the jobs, which must be in dependency order, returning
+// the error from each in the same order as the jobs. A job waits for
+// the jobs it depends on to finish, and is skipped if any of them
+// failed.
+func (r *Runner) execJobs(ctx context.Context, now time.Time, interval time.Duration, jobs []*Job) []error {
+	errs := make([]error, len(jobs))
+	index := make(map[string]int, len(jobs))
+	for i, j := range jobs {
+		index[j.Name] = i
+	}
+	// started is set by run when it starts a job, or by abandonJobs for
+	// a job it stops from starting
+	started := make([]atomic.Bool, len(jobs))
+	// run runs the job at i once the jobs it depends on have finished
+	run := func(i int) {
+		j := jobs[i]
+		if !started[i].CompareAndSwap(false, true) {
+			return
+		}
+		for _, d := range j.DependsOn {
+			if k, ok := index[d]; ok && errs[k] != nil {
+				r.release(j)
+				r.jobLogf(j, now, "skipping, dependency %s failed", d)
+				errs[i] = errors.Errorf("job %s skipped due to failed dependency %s", j.Name, d)
+				return
+			}
+		}
+		errs[i] = r.runJob(ctx, j, now, interval)
+	}
+	done := make([]chan struct{}, len(jobs))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	finished := make(chan struct{})
+	if r.sequential {
+		go func() {
+			defer close(finished)
+			for i := range jobs {
+				run(i)
+				close(done[i])
+			}
+		}()
+	} else {
+		go func() {
+			defer close(finished)
+			r.execConcurrently(jobs, index, done, run)
+		}()
+	}
+	select {
+	case <-finished:
+		return errs
+	case <-ctx.Done():
+	}
+	return r.abandonJobs(jobs, errs, started, done, finished)
+}
+
+// execConcurrently calls run for each of the jobs at once, up to the
+// max concurrency, closing the job's done channel after. A job waits
+// for the jobs it depends on to be done first.
+func (r *Runner) execConcurrently(jobs []*Job, index map[string]int, done []chan struct{}, run func(int)) {
+	// each goroutine records its own error and returns nil, so the
+	// group doesn't stop at the first failure
+	var g errgroup.Group
+	if r.maxConcurrency > 0 {
+		g.SetLimit(r.maxConcurrency)
+	}
+	// the jobs are started in dependency order, so a job waiting for
+	// its dependencies can't hold up a job it depends on
+	for i, j := range jobs {
+		i, j := i, j
+		g.Go(func() error {
+			defer close(done[i])
+			for _, d := range j.DependsOn {
+				if k, ok := index[d]; ok {
+					<-done[k]
+				}
+			}
+			run(i)
+			return nil
+		})
+	}
+	_ = g.Wait()
+}
+
+// abandonJobs returns the errors of the jobs that have finished once
+// ctx is cancelled, without waiting for the rest. Jobs that haven't
+// started yet won't be, and running jobs are reported to the jobs
+// abandoned hooks rather than as errors.
+func (r *Runner) abandonJobs(jobs []*Job, errs []error, started []atomic.Bool, done []chan struct{}, finished chan struct{}) []error {
+	select {
+	case <-finished:
+		return errs
+	default:
+	}
+	out := make([]error, len(jobs))
+	var abandoned []string
+	for i, j := range jobs {
+		select {
+		case <-done[i]:
+			out[i] = errs[i]
+		default:
+			if started[i].CompareAndSwap(false, true) {
+				r.release(j)
+				continue
+			}
+			abandoned = append(abandoned, j.Name)
+		}
+	}
+	if len(abandoned) == 0 {
+		return out
+	}
+	r.logf("stopping without waiting for jobs %s", strings.Join(abandoned, ", "))
+	for _, f := range r.onAbandoned {
+		f(abandoned)
+	}
+	return out
+}
@@ -0,0 +1,109 @@
+package ensureinterval
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// waitGoroutines waits up to a second for the number of goroutines to
+// fall to n, returning the number there are
+func waitGoroutines(n int) int {
+	deadline := time.Now().Add(time.Second)
+	for {
+		got := runtime.NumGoroutine()
+		if got <= n || time.Now().After(deadline) {
+			return got
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestProcessJobsFailingJobDoesNotLeak(t *testing.T) {
+	r := NewRunner()
+	jobs := []*Job{
+		{Name: "fail", Exec: func(context.Context) error { return errors.New("failed") }},
+		{Name: "ok", Exec: func(context.Context) error { return nil }},
+	}
+	before := runtime.NumGoroutine()
+	now := time.Now().Truncate(time.Minute)
+	for i := 0; i < 20; i++ {
+		err := r.processJobs(context.Background(), now, time.Minute, jobs, runStart)
+		var jobErrs *JobErrors
+		if !errors.As(err, &jobErrs) || len(jobErrs.Errors) != 1 || jobErrs.Errors["fail"] == nil {
+			t.Fatalf("expected a *JobErrors for the failing job, got %v", err)
+		}
+	}
+	if after := waitGoroutines(before); after > before {
+		t.Fatalf("goroutines leaked: %d before, %d after", before, after)
+	}
+}
+
+func TestPanickingJobIsAJobError(t *testing.T) {
+	var recovered interface{}
+	r := NewRunner(WithPanicHandler(func(job *Job, rec interface{}) {
+		recovered = rec
+	}))
+	jobs := []*Job{
+		{Name: "panics", Exec: func(context.Context) error { panic("boom") }},
+		{Name: "ok", Exec: func(context.Context) error { return nil }},
+	}
+	err := r.processJobs(context.Background(), time.Now().Truncate(time.Minute), time.Minute, jobs, runStart)
+	var jobErrs *JobErrors
+	if !errors.As(err, &jobErrs) {
+		t.Fatalf("expected a *JobErrors, got %v", err)
+	}
+	if len(jobErrs.Errors) != 1 {
+		t.Fatalf("expected only the panicking job to fail, got %v", jobErrs)
+	}
+	if msg := jobErrs.Errors["panics"].Error(); !strings.Contains(msg, "panic: boom") {
+		t.Fatalf("expected the error to describe the panic, got %q", msg)
+	}
+	if recovered != "boom" {
+		t.Fatalf("expected the panic handler to get the panic, got %v", recovered)
+	}
+}
+
+func TestManyFastJobsDoNotBlock(t *testing.T) {
+	const n = 1000
+	var ran atomic.Int64
+	jobs := make([]*Job, n)
+	for i := range jobs {
+		jobs[i] = &Job{
+			Name: fmt.Sprintf("job-%d", i),
+			Exec: func(context.Context) error {
+				ran.Add(1)
+				return nil
+			},
+		}
+	}
+	// one failure, so an error is collected while the other jobs are
+	// still waiting for one of the few slots to run in
+	jobs[0].Exec = func(context.Context) error {
+		ran.Add(1)
+		return errors.New("failed")
+	}
+	r := NewRunner(WithMaxConcurrency(4))
+	done := make(chan error, 1)
+	go func() {
+		done <- r.processJobs(context.Background(), time.Now().Truncate(time.Minute), time.Minute, jobs, runStart)
+	}()
+	select {
+	case err := <-done:
+		var jobErrs *JobErrors
+		if !errors.As(err, &jobErrs) || len(jobErrs.Errors) != 1 {
+			t.Fatalf("expected only the first job to fail, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("processJobs blocked")
+	}
+	if got := ran.Load(); got != n {
+		t.Fatalf("expected %d runs, got %d", n, got)
+	}
+}
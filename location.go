@@ -0,0 +1,38 @@
+package ensureinterval
+
+import (
+	"time"
+)
+
+// truncate returns t rounded down to a multiple of d, in the Runner's
+// location if it has one
+func (r *Runner) truncate(t time.Time, d time.Duration) time.Time {
+	if r.location == nil {
+		return t.Truncate(d)
+	}
+	t = t.In(r.location)
+	_, offset := t.Zone()
+	off := time.Duration(offset) * time.Second
+	// shift into civil time, truncate and shift back
+	civil := t.Add(off).Truncate(d)
+	b := civil.Add(-off).In(r.location)
+	if _, o := b.Zone(); o != offset {
+		// the offset changed between the boundary and t, so find the
+		// boundary from its civil time instead
+		y, m, day := civil.UTC().Date()
+		h, min, sec := civil.UTC().Clock()
+		b = time.Date(y, m, day, h, min, sec, civil.Nanosecond(), r.location)
+	}
+	return b
+}
+
+// nextBoundary returns the first multiple of d after the one t falls
+// in, in the Runner's location if it has one
+func (r *Runner) nextBoundary(t time.Time, d time.Duration) time.Time {
+	if r.location == nil {
+		return t.Truncate(d).Add(d)
+	}
+	// intervals are longer or shorter across DST changes, so overshoot
+	// by half an interval and truncate back to the boundary
+	return r.truncate(r.truncate(t, d).Add(d+d/2), d)
+}
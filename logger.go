@@ -1,35 +1,165 @@
 package ensureinterval
 
-type logger interface {
+import (
+	"context"
+	"time"
+)
+
+// Logger is the interface a logger must satisfy to be used with
+// SetLogger or WithLogger
+type Logger interface {
 	Debug(...interface{})
 	Debugf(string, ...interface{})
 	Printf(string, ...interface{})
 }
 
-var lg logger
+// FieldLogger is a Logger that can attach structured fields. If the
+// logger in use is a FieldLogger, messages about a job are logged with
+// job and scheduled_for fields rather than having the job name in the
+// message.
+type FieldLogger interface {
+	Logger
+	With(args ...interface{}) Logger
+}
+
+// ErrorLogger is a Logger that can log at the error level. If the
+// logger in use is an ErrorLogger, job failures are logged with Errorf
+// rather than Printf.
+type ErrorLogger interface {
+	Logger
+	Errorf(string, ...interface{})
+}
+
+// SetLogger sets a logger on the package that will print messages. It
+// is safe to call while running.
+func SetLogger(l Logger) {
+	defaultRunner.setLogger(l)
+}
+
+func (r *Runner) setLogger(l Logger) {
+	r.logMu.Lock()
+	defer r.logMu.Unlock()
+	r.logger = l
+}
+
+func (r *Runner) getLogger() Logger {
+	r.logMu.RLock()
+	defer r.logMu.RUnlock()
+	return r.logger
+}
 
-// SetLogger sets a logger on the package that will print messages
-func SetLogger(l logger) {
-	lg = l
+// namedLogger returns the logger to use for messages from the Runner
+// and the prefix to give them, tagging them with the Runner's name if
+// it has one
+func (r *Runner) namedLogger() (Logger, string) {
+	lg := r.getLogger()
+	if r.name == "" || lg == nil {
+		return lg, ""
+	}
+	if fl, ok := lg.(FieldLogger); ok {
+		return fl.With("runner", r.name), ""
+	}
+	return lg, r.name + ": "
 }
 
-func debug(a ...interface{}) {
+func (r *Runner) debug(a ...interface{}) {
+	lg, prefix := r.namedLogger()
 	if lg == nil {
 		return
 	}
+	if prefix != "" {
+		a = append([]interface{}{prefix}, a...)
+	}
 	lg.Debug(a...)
 }
 
-func debugf(f string, a ...interface{}) {
+func (r *Runner) debugf(f string, a ...interface{}) {
+	lg, prefix := r.namedLogger()
 	if lg == nil {
 		return
 	}
-	lg.Debugf(f, a...)
+	lg.Debugf(prefix+f, a...)
 }
 
-func logf(f string, a ...interface{}) {
+func (r *Runner) logf(f string, a ...interface{}) {
+	lg, prefix := r.namedLogger()
 	if lg == nil {
 		return
 	}
+	lg.Printf(prefix+f, a...)
+}
+
+// errorf logs with Errorf if lg is an ErrorLogger, and Printf if not
+func errorf(lg Logger, f string, a ...interface{}) {
+	if el, ok := lg.(ErrorLogger); ok {
+		el.Errorf(f, a...)
+		return
+	}
 	lg.Printf(f, a...)
 }
+
+// jobLogger returns the logger to use for messages about job and the
+// prefix to give them. id is the run ID, if the message is about a
+// single run.
+func (r *Runner) jobLogger(job *Job, now time.Time, id string) (Logger, string) {
+	lg, prefix := r.namedLogger()
+	if fl, ok := lg.(FieldLogger); ok {
+		if id != "" {
+			return fl.With("job", job.Name, "scheduled_for", now, "run_id", id), ""
+		}
+		return fl.With("job", job.Name, "scheduled_for", now), ""
+	}
+	if id != "" {
+		return lg, prefix + "job " + job.Name + " [" + id + "]: "
+	}
+	return lg, prefix + "job " + job.Name + ": "
+}
+
+func (r *Runner) jobDebugf(job *Job, now time.Time, f string, a ...interface{}) {
+	lg, prefix := r.jobLogger(job, now, "")
+	if lg == nil {
+		return
+	}
+	lg.Debugf(prefix+f, a...)
+}
+
+func (r *Runner) jobLogf(job *Job, now time.Time, f string, a ...interface{}) {
+	lg, prefix := r.jobLogger(job, now, "")
+	if lg == nil {
+		return
+	}
+	lg.Printf(prefix+f, a...)
+}
+
+// runDebugf is the same as jobDebugf, for messages about the run ctx
+// belongs to
+func (r *Runner) runDebugf(ctx context.Context, job *Job, f string, a ...interface{}) {
+	info, _ := RunInfoFromContext(ctx)
+	lg, prefix := r.jobLogger(job, info.ScheduledFor, info.ID)
+	if lg == nil {
+		return
+	}
+	lg.Debugf(prefix+f, a...)
+}
+
+// runLogf is the same as jobLogf, for messages about the run ctx
+// belongs to
+func (r *Runner) runLogf(ctx context.Context, job *Job, f string, a ...interface{}) {
+	info, _ := RunInfoFromContext(ctx)
+	lg, prefix := r.jobLogger(job, info.ScheduledFor, info.ID)
+	if lg == nil {
+		return
+	}
+	lg.Printf(prefix+f, a...)
+}
+
+// runErrorf is the same as runLogf, for failures of the run ctx
+// belongs to
+func (r *Runner) runErrorf(ctx context.Context, job *Job, f string, a ...interface{}) {
+	info, _ := RunInfoFromContext(ctx)
+	lg, prefix := r.jobLogger(job, info.ScheduledFor, info.ID)
+	if lg == nil {
+		return
+	}
+	errorf(lg, prefix+f, a...)
+}
@@ -0,0 +1,63 @@
+package ensureinterval
+
+import (
+	"time"
+)
+
+// NextRun returns when the named job will next be run, going by the
+// interval the Runner is running with, the job's schedule and any
+// backoff after failures. The zero time is returned if the job won't
+// be run again because it is disabled, expired or done. ErrNotRunning
+// is returned if the Runner isn't running.
+func (r *Runner) NextRun(name string) (time.Time, error) {
+	r.mu.Lock()
+	active, started := r.active, r.startedAt
+	r.mu.Unlock()
+	if !active {
+		return time.Time{}, ErrNotRunning
+	}
+	job, interval, err := r.findJob(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if r.isDisabled(job) || r.isFinished(name) {
+		return time.Time{}, nil
+	}
+	var next time.Time
+	if job.Cron != "" {
+		// the schedule fires after now and after any hold off
+		after := r.clock.Now()
+		if b := r.getBackoff(name).Add(-time.Nanosecond); b.After(after) {
+			after = b
+		}
+		if s := started.Add(job.StartAfter - time.Nanosecond); job.StartAfter > 0 && s.After(after) {
+			after = s
+		}
+		if next = r.cronNext(job, after, interval); next.IsZero() {
+			return next, nil
+		}
+	} else {
+		period := r.period(job, interval)
+		next = r.nextBoundary(r.clock.Now(), period)
+		next = r.nextAfter(next, r.getBackoff(name), period)
+		if job.StartAfter > 0 {
+			next = r.nextAfter(next, started.Add(job.StartAfter), period)
+		}
+	}
+	if job.expired(next) {
+		return time.Time{}, nil
+	}
+	return next, nil
+}
+
+// nextAfter returns next, or if that is before t the first multiple of
+// period not before t
+func (r *Runner) nextAfter(next, t time.Time, period time.Duration) time.Time {
+	if !next.Before(t) {
+		return next
+	}
+	if next = r.truncate(t, period); next.Before(t) {
+		next = r.nextBoundary(next, period)
+	}
+	return next
+}
@@ -0,0 +1,77 @@
+package ensureinterval
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// AddJob adds a job to the Runner's job registry. The registry is used
+// in place of a JobLoader when the Runner is run with a nil loader, so
+// jobs can be added and removed while it runs. An error matching
+// ErrDuplicateJobName is returned if a job with the same name is
+// already registered.
+func (r *Runner) AddJob(j *Job) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if j.Name == "" {
+		taken := map[string]bool{}
+		for _, o := range r.registry {
+			taken[o.Name] = true
+		}
+		j.Name = defaultJobName(j, len(r.registry), taken)
+	}
+	for _, o := range r.registry {
+		if o.Name == j.Name {
+			return errors.Wrapf(ErrDuplicateJobName, "job %s", j.Name)
+		}
+	}
+	r.registry = append(r.registry, j)
+	delete(r.finished, j.Name)
+	return nil
+}
+
+// RemoveJob removes the named job from the Runner's job registry. An
+// error matching ErrUnknownJob is returned if it isn't registered.
+func (r *Runner) RemoveJob(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, j := range r.registry {
+		if j.Name == name {
+			r.registry = append(r.registry[:i:i], r.registry[i+1:]...)
+			return nil
+		}
+	}
+	return errors.Wrapf(ErrUnknownJob, "job %s", name)
+}
+
+// finish stops the job being run again after it returned ErrJobDone
+func (r *Runner) finish(job *Job, now time.Time) {
+	r.mu.Lock()
+	r.finished[job.Name] = true
+	for i, j := range r.registry {
+		if j.Name == job.Name {
+			r.registry = append(r.registry[:i:i], r.registry[i+1:]...)
+			break
+		}
+	}
+	r.mu.Unlock()
+	r.jobLogf(job, now, "done, removing")
+}
+
+// isFinished returns true if the named job has returned ErrJobDone
+func (r *Runner) isFinished(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.finished[name]
+}
+
+// registeredJobs is the JobLoader used when the Runner is run without
+// one, returning a copy of the registry
+func (r *Runner) registeredJobs() ([]*Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	jobs := make([]*Job, len(r.registry))
+	copy(jobs, r.registry)
+	return jobs, nil
+}
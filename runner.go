@@ -0,0 +1,532 @@
+package ensureinterval
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"golang.org/x/time/rate"
+)
+
+// Runner runs jobs at intervals. Each Runner holds its own settings,
+// so several independent Runners can be used in one process.
+type Runner struct {
+	name              string
+	clock             Clock
+	baseCtx           context.Context
+	location          *time.Location
+	alignStart        bool
+	runOnStart        bool
+	catchup           bool
+	maxCatchups       atomic.Int64
+	maxCatchupAge     time.Duration
+	concurrentCatchup int
+	catchupPolicy     CatchupPolicy
+	logMu             sync.RWMutex
+	logger            Logger
+	panicHandler      func(*Job, interface{})
+	middleware        []Middleware
+
+	continueOnError bool
+	errorHandler    func(error) error
+	errorOnNoJobs   bool
+
+	minSleep  time.Duration
+	maxJitter time.Duration
+	randMu    sync.Mutex
+	rand      *rand.Rand
+
+	maxConcurrency int
+	sequential     bool
+	limiter        *rate.Limiter
+	dryRun         bool
+	jobTimeout     time.Duration
+	startupCheckOn bool
+
+	loaderInterval  time.Duration
+	maxLoadFailures int
+
+	stateStore StateStore
+	stateMu    sync.Mutex
+
+	onJobStart    []func(string, time.Time)
+	onJobComplete []func(string, time.Time, time.Duration, error)
+	onCatchup     []func(int, time.Duration)
+	onJobDisabled []func(string, error)
+	onSlowJob     []func(string, time.Duration, time.Duration)
+	onTick        []func(time.Time, time.Duration, bool)
+	onAbandoned   []func([]string)
+
+	events        chan Event
+	eventsOn      atomic.Bool
+	eventsDropped atomic.Uint64
+
+	maxRuntime time.Duration
+
+	// catchups counts the intervals caught up since the Runner was
+	// created
+	catchups atomic.Int64
+	// sleeps counts the times the Runner has slept until its next
+	// interval
+	sleeps atomic.Uint64
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+	doneOnce sync.Once
+	// drainCtx is cancelled by StopWithTimeout to cancel the running
+	// jobs
+	drainCtx    context.Context
+	cancelDrain context.CancelCauseFunc
+
+	mu             sync.Mutex
+	idle           *sync.Cond
+	running        int
+	runningJobs    map[string]int
+	drainExpired   bool
+	active         bool
+	startedAt      time.Time
+	lastTick       time.Time
+	lag            time.Duration
+	interval       time.Duration
+	jobs           []*Job
+	loader         JobLoader
+	loadFailures   int
+	loadedAt       time.Time
+	registry       []*Job
+	inFlight       map[string]bool
+	stats          map[string]*JobStat
+	expired        map[string]bool
+	noJobs         bool
+	disabled       map[string]bool
+	disabledGroups map[string]bool
+	finished       map[string]bool
+	notBefore      map[string]time.Time
+	frequencies    map[string]time.Duration
+	lastRuns       map[string]time.Time
+	buckets        map[string]map[int64]bool
+	schedules      map[string]cron.Schedule
+	coalesced      map[*Job]time.Time
+}
+
+// Option is a function that configures a Runner. Options that set an
+// On callback add to the callbacks already set, so they can be given
+// more than once.
+type Option func(*Runner)
+
+// the Runner used by the package level functions
+var defaultRunner = NewRunner()
+
+// NewRunner creates a new Runner with the provided options applied.
+func NewRunner(opts ...Option) *Runner {
+	r := &Runner{
+		clock:          realClock{},
+		catchup:        true,
+		rand:           rand.New(rand.NewSource(time.Now().UnixNano())),
+		inFlight:       map[string]bool{},
+		runningJobs:    map[string]int{},
+		stats:          map[string]*JobStat{},
+		expired:        map[string]bool{},
+		disabled:       map[string]bool{},
+		disabledGroups: map[string]bool{},
+		finished:       map[string]bool{},
+		notBefore:      map[string]time.Time{},
+		frequencies:    map[string]time.Duration{},
+		lastRuns:       map[string]time.Time{},
+		buckets:        map[string]map[int64]bool{},
+		schedules:      map[string]cron.Schedule{},
+		coalesced:      map[*Job]time.Time{},
+		stop:           make(chan struct{}),
+		done:           make(chan struct{}),
+		events:         make(chan Event, eventBuffer),
+	}
+	r.idle = sync.NewCond(&r.mu)
+	r.drainCtx, r.cancelDrain = context.WithCancelCause(context.Background())
+	r.maxCatchups.Store(20)
+	r.eventHooks()
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// WithMaxCatchup sets the max intervals the Runner is allowed to try
+// to catch up. The default is 20.
+func WithMaxCatchup(max int) Option {
+	return func(r *Runner) {
+		r.maxCatchups.Store(int64(max))
+	}
+}
+
+// WithName sets a name for the Runner that its log messages are tagged
+// with, to tell apart the messages of several Runners. Messages are
+// prefixed with the name, or given a runner field if the logger is a
+// FieldLogger.
+func WithName(name string) Option {
+	return func(r *Runner) {
+		r.name = name
+	}
+}
+
+// WithContext binds the Runner to ctx. The contexts passed to jobs are
+// derived from it, and once it is cancelled the Runner stops, waits for
+// running jobs to finish and returns ctx.Err(), the same as if ctx was
+// passed to RunContext. The Runner can't be run again after that.
+func WithContext(ctx context.Context) Option {
+	return func(r *Runner) {
+		r.baseCtx = ctx
+	}
+}
+
+// WithLocation sets the location interval boundaries are worked out
+// in, so they fall on the civil time there rather than on multiples of
+// the interval since the zero time in UTC. With a location a 24h
+// interval runs at local midnight, and an interval is shorter or longer
+// when it spans a DST change. A boundary in an hour skipped by a DST
+// change runs at the time time.Date normalises it to, and a repeated
+// hour runs each of its boundaries once for each offset. RunTicker
+// still ticks at a fixed period, so prefer Run for intervals longer
+// than a DST change.
+func WithLocation(loc *time.Location) Option {
+	return func(r *Runner) {
+		r.location = loc
+	}
+}
+
+// WithAlignStart sets whether the Runner waits for the next interval
+// boundary before its first run. By default the first run happens
+// straight away, for the interval the start time falls in. When
+// aligned, the partly passed interval is skipped and not caught up.
+func WithAlignStart(align bool) Option {
+	return func(r *Runner) {
+		r.alignStart = align
+	}
+}
+
+// WithRunOnStart sets whether the Runner runs every job on its first
+// interval, whether or not they are due, before going on with the
+// normal schedule.
+func WithRunOnStart(run bool) Option {
+	return func(r *Runner) {
+		r.runOnStart = run
+	}
+}
+
+// WithCatchup sets whether the Runner catches up intervals missed
+// while jobs were running. When disabled, missed intervals are skipped
+// and the Runner waits for the next interval boundary. The default is
+// to catch up.
+func WithCatchup(catchup bool) Option {
+	return func(r *Runner) {
+		r.catchup = catchup
+	}
+}
+
+// WithMaxCatchupAge sets how far behind the current time a catchup
+// may be. Catchups of intervals older than d are skipped. Zero means
+// no limit, which is the default.
+func WithMaxCatchupAge(d time.Duration) Option {
+	return func(r *Runner) {
+		r.maxCatchupAge = d
+	}
+}
+
+// WithCatchupExceededPolicy sets what the Runner does when it reaches
+// its max catchups. The default is PolicyFail.
+func WithCatchupExceededPolicy(p CatchupPolicy) Option {
+	return func(r *Runner) {
+		r.catchupPolicy = p
+	}
+}
+
+// WithConcurrentCatchup sets how many missed intervals the Runner may
+// catch up at once. By default they are caught up one after another,
+// in order. Running them concurrently is faster, but the intervals may
+// run in any order, so it should only be used with jobs that don't
+// depend on it. The errors from each are handled as usual, and if more
+// than one interval fails Run returns all of their errors joined.
+func WithConcurrentCatchup(n int) Option {
+	return func(r *Runner) {
+		r.concurrentCatchup = n
+	}
+}
+
+// WithLogger sets a logger on the Runner that will print messages
+func WithLogger(l Logger) Option {
+	return func(r *Runner) {
+		r.logger = l
+	}
+}
+
+// WithPanicHandler sets a function to be called with the recovered
+// value when a job panics. The panic is still reported as an error
+// for that job.
+func WithPanicHandler(h func(job *Job, recovered interface{})) Option {
+	return func(r *Runner) {
+		r.panicHandler = h
+	}
+}
+
+// WithJobMiddleware adds a Middleware that is applied to every job the
+// Runner executes. Middleware is applied in the order it is added, so
+// the first added is the outermost and runs first.
+func WithJobMiddleware(mw Middleware) Option {
+	return func(r *Runner) {
+		r.middleware = append(r.middleware, mw)
+	}
+}
+
+// WithContinueOnError sets whether the Runner keeps running when jobs
+// return errors. The errors are still logged. The default is to stop
+// and return the error.
+func WithContinueOnError(cont bool) Option {
+	return func(r *Runner) {
+		r.continueOnError = cont
+	}
+}
+
+// WithErrorHandler sets a function to be called with the *JobErrors
+// whenever jobs fail. If it returns nil the Runner keeps running,
+// otherwise the Runner stops and returns that error. This takes
+// precedence over WithContinueOnError.
+func WithErrorHandler(h func(err error) error) Option {
+	return func(r *Runner) {
+		r.errorHandler = h
+	}
+}
+
+// WithErrorOnNoJobs sets whether the Runner stops and returns
+// ErrNoJobs when the JobLoader returns no jobs, or once all of its jobs
+// have expired or are done, rather than running forever with nothing
+// to do.
+func WithErrorOnNoJobs(b bool) Option {
+	return func(r *Runner) {
+		r.errorOnNoJobs = b
+	}
+}
+
+// WithMinSleep sets the least time Run sleeps between intervals. When
+// the jobs overrun their interval Run doesn't sleep at all, so a Runner
+// that is always behind (for example with catchups skipped or at their
+// max) loops straight through one interval after another without a
+// pause. A minimum sleep paces it at the cost of falling further
+// behind. The default is zero. RunTicker is paced by its ticker and is
+// not affected.
+func WithMinSleep(d time.Duration) Option {
+	return func(r *Runner) {
+		r.minSleep = d
+	}
+}
+
+// WithJitter adds a random delay in [0, maxJitter) to each sleep
+// between intervals, so many Runners on the same interval don't all
+// fire at once. The interval alignment is not affected. RunTicker is
+// phase locked to its ticker, so no jitter is added to its runs.
+func WithJitter(maxJitter time.Duration) Option {
+	return func(r *Runner) {
+		r.maxJitter = maxJitter
+	}
+}
+
+// WithRandSource sets the source of the random numbers the Runner
+// uses for jitter and run IDs, which defaults to one seeded with the
+// time it was created. A source with a fixed seed makes them the same
+// every run, for tests.
+func WithRandSource(src rand.Source) Option {
+	return func(r *Runner) {
+		r.rand = rand.New(src)
+	}
+}
+
+// WithSeed is the same as WithRandSource, with a source seeded with
+// seed.
+func WithSeed(seed int64) Option {
+	return WithRandSource(rand.NewSource(seed))
+}
+
+// WithMaxConcurrency limits how many of the jobs due in an interval
+// the Runner will execute at once. Jobs over the limit wait for a
+// running job to finish. Zero means no limit, which is the default.
+func WithMaxConcurrency(n int) Option {
+	return func(r *Runner) {
+		r.maxConcurrency = n
+	}
+}
+
+// WithRateLimit limits how many jobs the Runner starts each second, so
+// a burst of catchups doesn't overwhelm what the jobs call. Jobs over
+// the limit wait to start. Zero means no limit, which is the default.
+func WithRateLimit(perSecond float64) Option {
+	return func(r *Runner) {
+		if perSecond <= 0 {
+			r.limiter = nil
+			return
+		}
+		r.limiter = rate.NewLimiter(rate.Limit(perSecond), 1)
+	}
+}
+
+// WithSequential sets whether the Runner executes the due jobs one
+// after another, in the order they were loaded, rather than all at
+// once. The default is to run them concurrently.
+func WithSequential(seq bool) Option {
+	return func(r *Runner) {
+		r.sequential = seq
+	}
+}
+
+// WithDryRun sets whether the Runner only logs the jobs it would run
+// for each interval, including catchups, without running them.
+// Triggered runs are still run.
+func WithDryRun(dryRun bool) Option {
+	return func(r *Runner) {
+		r.dryRun = dryRun
+	}
+}
+
+// WithLoaderInterval sets how often the Runner calls its JobLoader.
+// Between loads the jobs last loaded are reused. Jobs are only loaded
+// at the start of an interval, never while catching up. Zero loads the
+// jobs every interval, which is the default.
+func WithLoaderInterval(d time.Duration) Option {
+	return func(r *Runner) {
+		r.loaderInterval = d
+	}
+}
+
+// WithMaxLoadFailures sets how many times in a row the JobLoader may
+// fail before the Runner stops and returns the error. Until then the
+// error is logged and the jobs last loaded are run. The default of zero
+// stops on the first failure.
+func WithMaxLoadFailures(n int) Option {
+	return func(r *Runner) {
+		r.maxLoadFailures = n
+	}
+}
+
+// WithStartupCheck sets whether the Runner runs every job once when it
+// starts, before its first interval, and stops with a *JobErrors
+// rather than starting if any fail. This catches a misconfigured job
+// at startup instead of at its first run. The check respects the jobs'
+// timeouts and doesn't count as a run for their stats or schedule.
+func WithStartupCheck(check bool) Option {
+	return func(r *Runner) {
+		r.startupCheckOn = check
+	}
+}
+
+// WithStateStore sets a StateStore the Runner saves the interval time
+// each job last ran successfully for to. When the Runner starts it
+// loads the saved times, catches up the intervals of those jobs missed
+// while it was stopped, and doesn't run a job again for an interval it
+// already ran for.
+func WithStateStore(s StateStore) Option {
+	return func(r *Runner) {
+		r.stateStore = s
+	}
+}
+
+// WithJobTimeout sets the timeout for jobs that don't set their own
+// Timeout. Zero means no timeout, which is the default.
+func WithJobTimeout(d time.Duration) Option {
+	return func(r *Runner) {
+		r.jobTimeout = d
+	}
+}
+
+// WithMaxRuntime sets how long the Runner runs for before it stops, the
+// same as if Stop was called then. Jobs still running are waited for
+// and Run returns nil. Zero means no limit, which is the default.
+func WithMaxRuntime(d time.Duration) Option {
+	return func(r *Runner) {
+		r.maxRuntime = d
+	}
+}
+
+// WithOnJobStart sets a function to be called when a job starts, with
+// the job name and the interval time it was scheduled for.
+func WithOnJobStart(f func(name string, scheduledFor time.Time)) Option {
+	return func(r *Runner) {
+		r.onJobStart = append(r.onJobStart, f)
+	}
+}
+
+// WithOnJobComplete sets a function to be called when a job finishes,
+// with the job name, the interval time it was scheduled for, how long
+// it ran and the error it returned, if any.
+func WithOnJobComplete(f func(name string, scheduledFor time.Time, dur time.Duration, err error)) Option {
+	return func(r *Runner) {
+		r.onJobComplete = append(r.onJobComplete, f)
+	}
+}
+
+// WithOnCatchup sets a function to be called when the Runner starts
+// catching up missed intervals, with how many intervals were missed
+// and how far behind schedule the Runner is. It is called once for
+// each batch of catchups, not for each job.
+func WithOnCatchup(f func(missed int, behind time.Duration)) Option {
+	return func(r *Runner) {
+		r.onCatchup = append(r.onCatchup, f)
+	}
+}
+
+// WithOnJobDisabled sets a function to be called when a job is
+// disabled for reaching its MaxConsecutiveFailures, with the job name
+// and the error from its last run.
+func WithOnJobDisabled(f func(name string, lastErr error)) Option {
+	return func(r *Runner) {
+		r.onJobDisabled = append(r.onJobDisabled, f)
+	}
+}
+
+// WithOnSlowJob sets a function to be called when a job takes longer
+// to run than its period, with the job name, how long it took and its
+// period.
+func WithOnSlowJob(f func(name string, took, period time.Duration)) Option {
+	return func(r *Runner) {
+		r.onSlowJob = append(r.onSlowJob, f)
+	}
+}
+
+// WithOnTickComplete sets a function to be called when the Runner has
+// finished an interval, including catching up any it missed, with the
+// interval time, how long it has been since then, and whether that was
+// longer than the interval. An interval that often overruns is too
+// short for the jobs being run.
+func WithOnTickComplete(f func(scheduledFor time.Time, elapsed time.Duration, overran bool)) Option {
+	return func(r *Runner) {
+		r.onTick = append(r.onTick, f)
+	}
+}
+
+// WithOnJobsAbandoned sets a function to be called when the Runner's
+// context is cancelled while jobs of the interval are still running, or
+// waiting to run, with the names of the jobs. Rather than waiting for them,
+// the Runner stops and leaves them running, so a job that ignores its
+// context can't hold up shutdown.
+func WithOnJobsAbandoned(f func(names []string)) Option {
+	return func(r *Runner) {
+		r.onAbandoned = append(r.onAbandoned, f)
+	}
+}
+
+// jitter returns a random delay to add to the sleep between intervals
+func (r *Runner) jitter() time.Duration {
+	if r.maxJitter <= 0 {
+		return 0
+	}
+	r.randMu.Lock()
+	defer r.randMu.Unlock()
+	return time.Duration(r.rand.Int63n(int64(r.maxJitter)))
+}
+
+// runID returns a random ID for a single run of a job
+func (r *Runner) runID() string {
+	r.randMu.Lock()
+	defer r.randMu.Unlock()
+	return fmt.Sprintf("%08x", r.rand.Uint32())
+}
@@ -0,0 +1,433 @@
+package ensureinterval_test
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/dangersalad/go-ensureinterval"
+	"github.com/dangersalad/go-ensureinterval/ensureintervaltest"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// logger is a Logger that keeps the messages logged to it, and can be
+// used from many goroutines at once
+type logger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *logger) Debug(a ...interface{}) {
+	l.log(fmt.Sprint(a...))
+}
+
+func (l *logger) Debugf(f string, a ...interface{}) {
+	l.log(fmt.Sprintf(f, a...))
+}
+
+func (l *logger) Printf(f string, a ...interface{}) {
+	l.log(fmt.Sprintf(f, a...))
+}
+
+func (l *logger) log(m string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, m)
+}
+
+func (l *logger) Messages() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.messages...)
+}
+
+// run runs r with the jobs in the background until the test ends
+func run(t *testing.T, r *ensureinterval.Runner, interval time.Duration, jobs ...*ensureinterval.Job) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = r.RunContext(ctx, interval, func() ([]*ensureinterval.Job, error) {
+			return jobs, nil
+		})
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+// receive returns the next time sent on c, failing the test if none is
+// sent soon
+func receive(t *testing.T, c <-chan time.Time) time.Time {
+	t.Helper()
+	select {
+	case at := <-c:
+		return at
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the job to run")
+		return time.Time{}
+	}
+}
+
+// notRun fails the test if anything has been sent on c once the Runner
+// using the clock is asleep again
+func notRun(t *testing.T, clock *ensureintervaltest.FakeClock, c <-chan time.Time) {
+	t.Helper()
+	// if the Runner was woken it runs and goes back to sleep before
+	// this returns
+	clock.BlockUntil(1)
+	select {
+	case at := <-c:
+		t.Fatalf("unexpected run at %s", at)
+	default:
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	c := ensureintervaltest.NewFakeClock(epoch)
+	r := ensureinterval.NewRunner(ensureinterval.WithClock(c), ensureinterval.WithRunOnStart(true))
+	attempts := make(chan time.Time, 10)
+	run(t, r, time.Hour, &ensureinterval.Job{
+		Name:            "flaky",
+		MaxRetries:      4,
+		RetryBackoff:    time.Second,
+		RetryBackoffMax: 3 * time.Second,
+		Exec: func(context.Context) error {
+			attempts <- c.Now()
+			return errors.New("failed")
+		},
+	})
+
+	last := receive(t, attempts)
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		// wait for the backoff to start, and check the retry doesn't
+		// come any sooner than it should
+		c.BlockUntil(1)
+		c.Advance(want - time.Nanosecond)
+		notRun(t, c, attempts)
+		c.Advance(time.Nanosecond)
+		at := receive(t, attempts)
+		if got := at.Sub(last); got != want {
+			t.Fatalf("retry %d came after %s, expected %s", i+1, got, want)
+		}
+		last = at
+	}
+}
+
+func TestSetMaxCatchupWhileRunning(t *testing.T) {
+	defer ensureinterval.SetMaxCatchup(20)
+	const interval = 10 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	var catchups atomic.Int64
+	job := &ensureinterval.Job{
+		Name: "slow",
+		Exec: func(ctx context.Context) error {
+			if at, _ := ensureinterval.ScheduledTime(ctx); time.Since(at) > interval {
+				catchups.Add(1)
+			}
+			time.Sleep(3 * interval)
+			return nil
+		},
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ctx.Err() == nil; i++ {
+			ensureinterval.SetMaxCatchup(1000 + i%2)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	err := ensureinterval.RunContext(ctx, interval, func() ([]*ensureinterval.Job, error) {
+		return []*ensureinterval.Job{job}, nil
+	})
+	wg.Wait()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to stop the runner, got %v", err)
+	}
+	if catchups.Load() == 0 {
+		t.Fatal("expected the runner to catch up while the max was set")
+	}
+}
+
+func TestSetLoggerWhileRunning(t *testing.T) {
+	defer ensureinterval.SetLogger(nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	jobs := make([]*ensureinterval.Job, 10)
+	for i := range jobs {
+		jobs[i] = &ensureinterval.Job{
+			Name: fmt.Sprintf("job%d", i),
+			Exec: func(context.Context) error { return nil },
+		}
+	}
+
+	loggers := []*logger{{}, {}}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ctx.Err() == nil; i++ {
+			ensureinterval.SetLogger(loggers[i%2])
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	err := ensureinterval.RunContext(ctx, 10*time.Millisecond, func() ([]*ensureinterval.Job, error) {
+		return jobs, nil
+	})
+	wg.Wait()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to stop the runner, got %v", err)
+	}
+	for i, l := range loggers {
+		if len(l.Messages()) == 0 {
+			t.Errorf("expected logger %d to be logged to", i)
+		}
+	}
+}
+
+func TestRunningJobLogMessage(t *testing.T) {
+	c := ensureintervaltest.NewFakeClock(epoch)
+	l := &logger{}
+	r := ensureinterval.NewRunner(ensureinterval.WithClock(c), ensureinterval.WithLogger(l), ensureinterval.WithRunOnStart(true))
+	ran := make(chan time.Time, 1)
+	run(t, r, time.Hour, &ensureinterval.Job{
+		Name:      "report",
+		Frequency: 2,
+		Exec: func(context.Context) error {
+			ran <- c.Now()
+			return nil
+		},
+	})
+	receive(t, ran)
+
+	want := regexp.MustCompile(`^job report \[[0-9a-f]{8}\]: running for 2024-01-01 00:00:00 \+0000 UTC \(every 2h0m0s\)$`)
+	messages := l.Messages()
+	for _, m := range messages {
+		if want.MatchString(m) {
+			return
+		}
+	}
+	t.Fatalf("expected a message matching %s, got %q", want, messages)
+}
+
+func TestClockJumpWhileSleeping(t *testing.T) {
+	c := ensureintervaltest.NewFakeClock(epoch)
+	r := ensureinterval.NewRunner(ensureinterval.WithClock(c), ensureinterval.WithRunOnStart(true))
+	ran := make(chan time.Time, 10)
+	run(t, r, time.Hour, &ensureinterval.Job{
+		Name: "hourly",
+		Exec: func(ctx context.Context) error {
+			at, _ := ensureinterval.ScheduledTime(ctx)
+			ran <- at
+			return nil
+		},
+	})
+	if at := receive(t, ran); !at.Equal(epoch) {
+		t.Fatalf("expected the first run for %s, got %s", epoch, at)
+	}
+
+	// the sleep still ends an hour after it started, and the run is for
+	// the interval the clock is in then
+	for _, jump := range []time.Duration{5 * time.Hour, -10 * time.Hour} {
+		c.BlockUntil(1)
+		want := c.Now().Add(jump + time.Hour).Truncate(time.Hour)
+		c.Jump(jump)
+		c.Advance(time.Hour - time.Nanosecond)
+		notRun(t, c, ran)
+		c.Advance(time.Nanosecond)
+		if at := receive(t, ran); !at.Equal(want) {
+			t.Fatalf("expected the run after jumping %s to be for %s, got %s", jump, want, at)
+		}
+	}
+
+	// and the runner sleeps on as normal rather than catching up
+	notRun(t, c, ran)
+	if n := r.CatchupCount(); n != 0 {
+		t.Fatalf("expected no catchups, got %d", n)
+	}
+}
+
+func TestMinSleepAfterLongJob(t *testing.T) {
+	const minSleep = 10 * time.Minute
+	c := ensureintervaltest.NewFakeClock(epoch)
+	r := ensureinterval.NewRunner(ensureinterval.WithClock(c), ensureinterval.WithRunOnStart(true), ensureinterval.WithMinSleep(minSleep))
+	ran := make(chan time.Time, 10)
+	var runs atomic.Int64
+	run(t, r, time.Hour, &ensureinterval.Job{
+		Name: "long",
+		Exec: func(context.Context) error {
+			if runs.Add(1) == 1 {
+				// take all but a second of the interval
+				c.Advance(time.Hour - time.Second)
+			}
+			ran <- c.Now()
+			return nil
+		},
+	})
+	finished := receive(t, ran)
+
+	c.BlockUntil(1)
+	if n := r.Sleeps(); n != 1 {
+		t.Fatalf("expected the runner to sleep once after the long job, got %d", n)
+	}
+	c.Advance(minSleep - time.Nanosecond)
+	notRun(t, c, ran)
+	c.Advance(time.Nanosecond)
+	if at := receive(t, ran); at.Sub(finished) != minSleep {
+		t.Fatalf("expected to run again after %s, got %s", minSleep, at.Sub(finished))
+	}
+}
+
+func TestJobTimeoutOnFakeClock(t *testing.T) {
+	c := ensureintervaltest.NewFakeClock(epoch)
+	r := ensureinterval.NewRunner(ensureinterval.WithClock(c), ensureinterval.WithRunOnStart(true))
+	cancelled := make(chan time.Time, 1)
+	run(t, r, time.Hour, &ensureinterval.Job{
+		Name:    "slow",
+		Timeout: 50 * time.Millisecond,
+		Exec: func(ctx context.Context) error {
+			<-ctx.Done()
+			cancelled <- c.Now()
+			return ctx.Err()
+		},
+	})
+
+	// the timeout is on the fake clock, so real time passing doesn't
+	// cancel the job
+	c.BlockUntil(1)
+	select {
+	case <-cancelled:
+		t.Fatal("job was cancelled before the fake clock moved")
+	case <-time.After(100 * time.Millisecond):
+	}
+	c.Advance(50 * time.Millisecond)
+	if at := receive(t, cancelled); !at.Equal(epoch.Add(50 * time.Millisecond)) {
+		t.Fatalf("expected the job to be cancelled at its timeout, got %s", at)
+	}
+}
+
+func TestConcurrentCatchupErrorsJoined(t *testing.T) {
+	c := ensureintervaltest.NewFakeClock(epoch)
+	r := ensureinterval.NewRunner(ensureinterval.WithClock(c), ensureinterval.WithRunOnStart(true), ensureinterval.WithConcurrentCatchup(3))
+	err := r.RunJobs(time.Hour, []*ensureinterval.Job{{
+		Name: "j",
+		Exec: func(ctx context.Context) error {
+			at, _ := ensureinterval.ScheduledTime(ctx)
+			if at.Equal(epoch) {
+				// overrun by three intervals
+				c.Advance(3*time.Hour + 30*time.Minute)
+				return nil
+			}
+			return errors.Errorf("failed for %s", at.Format("15:04"))
+		},
+	}})
+	if err == nil {
+		t.Fatal("expected the failed catchups to stop the runner")
+	}
+	for _, at := range []string{"01:00", "02:00", "03:00"} {
+		if !strings.Contains(err.Error(), "failed for "+at) {
+			t.Errorf("expected the error for %s in %q", at, err)
+		}
+	}
+	var jerr *ensureinterval.JobErrors
+	if !errors.As(err, &jerr) {
+		t.Errorf("expected the joined error to match JobErrors, got %T", err)
+	}
+}
+
+func TestClockJumpWhileRunning(t *testing.T) {
+	for _, jump := range []time.Duration{5 * time.Hour, -5 * time.Hour} {
+		c := ensureintervaltest.NewFakeClock(epoch)
+		r := ensureinterval.NewRunner(ensureinterval.WithClock(c), ensureinterval.WithRunOnStart(true))
+		ran := make(chan time.Time, 10)
+		var runs atomic.Int64
+		run(t, r, time.Hour, &ensureinterval.Job{
+			Name: "hourly",
+			Exec: func(ctx context.Context) error {
+				if runs.Add(1) == 1 {
+					c.Jump(jump)
+				}
+				at, _ := ensureinterval.ScheduledTime(ctx)
+				ran <- at
+				return nil
+			},
+		})
+		receive(t, ran)
+
+		// the job took no time, so the runner sleeps a whole interval
+		// and runs for the interval the clock is in then
+		c.BlockUntil(1)
+		c.Advance(time.Hour - time.Nanosecond)
+		notRun(t, c, ran)
+		c.Advance(time.Nanosecond)
+		if want, at := epoch.Add(jump+time.Hour), receive(t, ran); !at.Equal(want) {
+			t.Fatalf("expected the run after jumping %s to be for %s, got %s", jump, want, at)
+		}
+		if n := r.CatchupCount(); n != 0 {
+			t.Fatalf("expected no catchups after jumping %s, got %d", jump, n)
+		}
+	}
+}
+
+func TestSetFrequency(t *testing.T) {
+	c := ensureintervaltest.NewFakeClock(epoch)
+	r := ensureinterval.NewRunner(ensureinterval.WithClock(c))
+	nop := func(context.Context) error { return nil }
+	job := &ensureinterval.Job{Name: "job", Exec: nop}
+	periodic := &ensureinterval.Job{Name: "periodic", Period: 2 * time.Minute, Exec: nop}
+	run(t, r, time.Minute, job, periodic)
+	// wait for the jobs to be loaded
+	ensureintervaltest.RunFor(c, r, 0)
+
+	if err := r.SetFrequency("job", 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetFrequency("periodic", 5); !errors.Is(err, ensureinterval.ErrInvalidFrequency) {
+		t.Fatalf("expected ErrInvalidFrequency for a job with a Period, got %v", err)
+	}
+	runs := ensureintervaltest.RunFor(c, r, 10*time.Minute)
+	if runs["job"] != 2 {
+		t.Errorf("expected the job to run every 5 minutes, ran %d times in 10", runs["job"])
+	}
+	if job.Frequency != 0 {
+		t.Errorf("expected the job's own Frequency to be left alone, got %d", job.Frequency)
+	}
+}
+
+func TestStartupCheckResultNotRecorded(t *testing.T) {
+	c := ensureintervaltest.NewFakeClock(epoch)
+	r := ensureinterval.NewRunner(ensureinterval.WithClock(c), ensureinterval.WithStartupCheck(true))
+	var calls atomic.Int64
+	running := make(chan time.Time, 1)
+	run(t, r, time.Hour, &ensureinterval.Job{
+		Name: "result",
+		Result: func(ctx context.Context) (interface{}, error) {
+			if calls.Add(1) == 1 {
+				return "startup check", nil
+			}
+			// hold the first real run so the stats can be checked
+			// before it finishes
+			running <- c.Now()
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	})
+	receive(t, running)
+
+	if stat, ok := r.Stats()["result"]; ok {
+		t.Fatalf("expected no stats from the startup check, got %+v", stat)
+	}
+}
@@ -0,0 +1,43 @@
+package ensureinterval
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// RunUntilSignal is the same as Run, but stops once one of the given
+// signals is received, which defaults to SIGINT and SIGTERM. On a
+// signal the context passed to running jobs is cancelled, they are
+// waited on to finish and nil is returned. A second signal while they
+// finish is not caught, so it has its usual effect of ending the
+// process.
+func RunUntilSignal(interval time.Duration, getJobs JobLoader, sigs ...os.Signal) error {
+	return defaultRunner.RunUntilSignal(interval, getJobs, sigs...)
+}
+
+// RunUntilSignal runs the jobs using the settings of the Runner until
+// a signal is received. See the package level RunUntilSignal for
+// details.
+func (r *Runner) RunUntilSignal(interval time.Duration, getJobs JobLoader, sigs ...os.Signal) error {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), sigs...)
+	defer cancel()
+	// stop catching the signals as soon as one is received, so another
+	// ends the process as usual if the jobs don't finish
+	stopWatching := context.AfterFunc(ctx, func() {
+		cancel()
+		r.logf("received signal, waiting for running jobs")
+	})
+	defer stopWatching()
+	err := r.run(ctx, interval, getJobs, -1, true)
+	// the error is the signal's cause on Go versions that give one
+	if ctx.Err() != nil && err == context.Cause(ctx) {
+		return nil
+	}
+	return err
+}
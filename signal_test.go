@@ -0,0 +1,67 @@
+//go:build !windows
+
+package ensureinterval_test
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/dangersalad/go-ensureinterval"
+)
+
+func TestRunUntilSignalDrains(t *testing.T) {
+	r := ensureinterval.NewRunner(ensureinterval.WithRunOnStart(true))
+	started, finish := make(chan struct{}), make(chan struct{})
+	job := &ensureinterval.Job{
+		Name: "stubborn",
+		Exec: func(context.Context) error {
+			// ignore the context, like a job stuck in I/O
+			close(started)
+			<-finish
+			return nil
+		},
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- r.RunUntilSignal(time.Hour, func() ([]*ensureinterval.Job, error) {
+			return []*ensureinterval.Job{job}, nil
+		}, syscall.SIGUSR1)
+	}()
+	<-started
+
+	// catch the second signal here, so it doesn't end the test if the
+	// Runner wrongly stopped catching it
+	second := make(chan os.Signal, 2)
+	signal.Notify(second, syscall.SIGUSR1)
+	defer signal.Stop(second)
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	<-second
+
+	select {
+	case <-r.Done():
+		t.Fatal("runner was done before its running job finished")
+	case err := <-errc:
+		t.Fatalf("RunUntilSignal returned %v before its running job finished", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(finish)
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatalf("expected nil after the signal, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunUntilSignal didn't return once the job finished")
+	}
+	select {
+	case <-r.Done():
+	default:
+		t.Fatal("expected the runner to be done")
+	}
+}
@@ -0,0 +1,39 @@
+package ensureinterval
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// SlogLogger adapts a *slog.Logger for use with SetLogger or
+// WithLogger. Debug messages are logged at slog.LevelDebug, job
+// failures at slog.LevelError and other messages at slog.LevelInfo,
+// all with a component=ensureinterval attribute. It is a FieldLogger,
+// so job messages have job and scheduled_for attributes.
+func SlogLogger(l *slog.Logger) Logger {
+	return &slogLogger{l.With("component", "ensureinterval")}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (s *slogLogger) Debug(a ...interface{}) {
+	s.l.Debug(fmt.Sprint(a...))
+}
+
+func (s *slogLogger) Debugf(f string, a ...interface{}) {
+	s.l.Debug(fmt.Sprintf(f, a...))
+}
+
+func (s *slogLogger) Printf(f string, a ...interface{}) {
+	s.l.Info(fmt.Sprintf(f, a...))
+}
+
+func (s *slogLogger) Errorf(f string, a ...interface{}) {
+	s.l.Error(fmt.Sprintf(f, a...))
+}
+
+func (s *slogLogger) With(args ...interface{}) Logger {
+	return &slogLogger{s.l.With(args...)}
+}
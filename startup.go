@@ -0,0 +1,42 @@
+package ensureinterval
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// startupCheck runs every job once, one after another, if the Runner
+// is set to check its jobs on startup, and returns a *JobErrors if any
+// fail. The runs are not recorded in the stats or state, and don't
+// affect the schedule.
+func (r *Runner) startupCheck(ctx context.Context, interval time.Duration, getJobs JobLoader) error {
+	if !r.startupCheckOn {
+		return nil
+	}
+	jobs, err := r.loadJobs(interval, getJobs)
+	if err != nil {
+		return err
+	}
+	now := r.truncate(r.clock.Now(), interval)
+	errs := map[string]error{}
+	for _, j := range orderJobs(jobs) {
+		if r.isDisabled(j) || j.expired(now) {
+			continue
+		}
+		if !r.acquire(j) {
+			continue
+		}
+		runCtx := withStartupCheck(withRunInfo(ctx, RunInfo{ID: r.runID(), Job: j.Name, ScheduledFor: now, Interval: interval}))
+		r.runDebugf(runCtx, j, "running startup check")
+		if err := r.execJobTimeout(runCtx, j); retryable(err) {
+			r.runErrorf(runCtx, j, "startup check failed: %+v", err)
+			errs[j.Name] = errors.Wrapf(err, "startup check of job %s", j.Name)
+		}
+	}
+	if len(errs) > 0 {
+		return &JobErrors{Errors: errs}
+	}
+	return nil
+}
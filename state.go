@@ -0,0 +1,265 @@
+package ensureinterval
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// StateStore persists the interval time each job last ran for, so a
+// Runner can carry on where it left off after a restart. Set one on a
+// Runner with WithStateStore.
+type StateStore interface {
+	// Load returns the saved last run times, keyed by job name
+	Load() (map[string]time.Time, error)
+	// Save replaces the saved last run times
+	Save(map[string]time.Time) error
+}
+
+// BucketStore is a StateStore that also persists the interval times
+// each Idempotent job has run for successfully, so they aren't run
+// again when catching up after a restart.
+type BucketStore interface {
+	StateStore
+	// LoadBuckets returns the saved interval times, keyed by job name
+	LoadBuckets() (map[string][]time.Time, error)
+	// SaveBuckets replaces the saved interval times
+	SaveBuckets(map[string][]time.Time) error
+}
+
+// JSONFileStore returns a StateStore, which is also a BucketStore,
+// that keeps the state in a JSON file at path, and the interval times
+// of Idempotent jobs in another at path with ".buckets" added. A
+// missing file is loaded as empty state. The files are replaced rather
+// than written in place, so a crash while saving leaves the previous
+// state.
+func JSONFileStore(path string) StateStore {
+	return jsonFileStore(path)
+}
+
+type jsonFileStore string
+
+func (s jsonFileStore) Load() (map[string]time.Time, error) {
+	state := map[string]time.Time{}
+	if err := readJSONFile(string(s), &state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func (s jsonFileStore) Save(state map[string]time.Time) error {
+	return writeJSONFile(string(s), state)
+}
+
+func (s jsonFileStore) LoadBuckets() (map[string][]time.Time, error) {
+	buckets := map[string][]time.Time{}
+	if err := readJSONFile(string(s)+".buckets", &buckets); err != nil {
+		return nil, err
+	}
+	return buckets, nil
+}
+
+func (s jsonFileStore) SaveBuckets(buckets map[string][]time.Time) error {
+	return writeJSONFile(string(s)+".buckets", buckets)
+}
+
+// readJSONFile decodes the JSON file at path into v, leaving v as it is
+// if the file doesn't exist
+func readJSONFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "reading state file")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.Wrap(err, "parsing state file")
+	}
+	return nil
+}
+
+// writeJSONFile replaces the file at path with v encoded as JSON
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encoding state")
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return errors.Wrap(err, "writing state file")
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return errors.Wrap(err, "replacing state file")
+	}
+	return nil
+}
+
+// loadState reads the last run times from the Runner's state store
+func (r *Runner) loadState() error {
+	if r.stateStore == nil {
+		return nil
+	}
+	state, err := r.stateStore.Load()
+	if err != nil {
+		return errors.Wrap(err, "loading state")
+	}
+	r.mu.Lock()
+	for name, t := range state {
+		r.lastRuns[name] = t
+	}
+	r.mu.Unlock()
+	bs, ok := r.stateStore.(BucketStore)
+	if !ok {
+		return nil
+	}
+	buckets, err := bs.LoadBuckets()
+	if err != nil {
+		return errors.Wrap(err, "loading state")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, times := range buckets {
+		done := map[int64]bool{}
+		for _, t := range times {
+			done[t.UnixNano()] = true
+		}
+		r.buckets[name] = done
+	}
+	return nil
+}
+
+// lastRun returns the interval time the named job last ran for, if it
+// is known
+func (r *Runner) lastRun(name string) (time.Time, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.lastRuns[name]
+	return t, ok
+}
+
+// recordRun records that the named job ran for now and saves the state,
+// if the Runner has a state store. Failing to save is logged rather
+// than stopping the Runner.
+func (r *Runner) recordRun(name string, now time.Time) {
+	if r.stateStore == nil {
+		return
+	}
+	// hold stateMu while saving so saves can't overtake each other
+	r.stateMu.Lock()
+	defer r.stateMu.Unlock()
+	r.mu.Lock()
+	if now.After(r.lastRuns[name]) {
+		r.lastRuns[name] = now
+	}
+	state := make(map[string]time.Time, len(r.lastRuns))
+	for n, t := range r.lastRuns {
+		state[n] = t
+	}
+	r.mu.Unlock()
+	if err := r.stateStore.Save(state); err != nil {
+		r.logf("saving state: %s", err)
+	}
+}
+
+// bucketDone returns true if the named job has already run
+// successfully for now
+func (r *Runner) bucketDone(name string, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.buckets[name][now.UnixNano()]
+}
+
+// recordBucket records that the Idempotent named job ran for now and
+// saves the interval times, if the Runner has a BucketStore. Times too
+// old to be caught up again are dropped.
+func (r *Runner) recordBucket(name string, now time.Time, interval time.Duration) {
+	bs, ok := r.stateStore.(BucketStore)
+	if !ok {
+		return
+	}
+	oldest := r.truncate(r.clock.Now(), interval).Add(-interval * time.Duration(r.maxCatchups.Load()+1))
+	r.stateMu.Lock()
+	defer r.stateMu.Unlock()
+	r.mu.Lock()
+	done, ok := r.buckets[name]
+	if !ok {
+		done = map[int64]bool{}
+		r.buckets[name] = done
+	}
+	done[now.UnixNano()] = true
+	buckets := make(map[string][]time.Time, len(r.buckets))
+	for n, times := range r.buckets {
+		for t := range times {
+			if t < oldest.UnixNano() {
+				delete(times, t)
+				continue
+			}
+			buckets[n] = append(buckets[n], time.Unix(0, t))
+		}
+	}
+	r.mu.Unlock()
+	for _, times := range buckets {
+		sort.Slice(times, func(a, b int) bool {
+			return times[a].Before(times[b])
+		})
+	}
+	if err := bs.SaveBuckets(buckets); err != nil {
+		r.logf("saving state: %s", err)
+	}
+}
+
+// resume loads the saved state and catches up the intervals missed
+// since the jobs last ran. Only jobs with a saved last run are caught
+// up, and no more than the max catchups of the most recent intervals.
+func (r *Runner) resume(ctx context.Context, interval time.Duration, getJobs JobLoader) error {
+	if r.stateStore == nil {
+		return nil
+	}
+	if err := r.loadState(); err != nil {
+		return err
+	}
+	jobs, err := r.loadJobs(interval, getJobs)
+	if err != nil {
+		return err
+	}
+	var earliest time.Time
+	for _, j := range jobs {
+		if t, ok := r.lastRun(j.Name); ok && (earliest.IsZero() || t.Before(earliest)) {
+			earliest = t
+		}
+	}
+	if earliest.IsZero() {
+		return nil
+	}
+	now := r.truncate(r.clock.Now(), interval)
+	from := r.nextBoundary(earliest, interval)
+	if !from.Before(now) {
+		return nil
+	}
+	missed := int(now.Sub(from) / interval)
+	if max := r.maxCatchups.Load(); int64(missed) > max {
+		r.logf("skipping %d intervals missed while stopped, over the max catchups", int64(missed)-max)
+		from = now.Add(-interval * time.Duration(max))
+	}
+	for _, f := range r.onCatchup {
+		f(missed, r.clock.Now().Sub(from))
+	}
+	for ketchup := from; ketchup.Before(now) && !r.stopped(); ketchup = r.nextBoundary(ketchup, interval) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if r.staleCatchup(ketchup) {
+			r.logf("skipping stale catchup for %s", ketchup)
+			continue
+		}
+		if err := r.jobsError(ctx, r.processJobs(ctx, ketchup, interval, jobs, runResume)); err != nil {
+			return err
+		}
+	}
+	return r.runCoalesced(ctx, interval)
+}
@@ -0,0 +1,84 @@
+package ensureinterval
+
+import (
+	"time"
+)
+
+// JobStat holds the execution statistics of a job
+type JobStat struct {
+	// LastRun is when the last run of the job started
+	LastRun time.Time
+	// LastDuration is how long the last run of the job took
+	LastDuration time.Duration
+	// LastError is the error returned by the last run, if any
+	LastError error
+	// Runs is the total number of runs of the job
+	Runs int
+	// Failures is the total number of runs that returned an error
+	Failures int
+	// Skipped is the total number of runs that returned ErrSkipped,
+	// which are not counted in Runs
+	Skipped int
+	// ConsecutiveFailures is the number of runs in a row, up to the
+	// last, that returned an error
+	ConsecutiveFailures int
+	// LastResult is the result of the last successful run of a job
+	// with a Result function
+	LastResult interface{}
+}
+
+// Stats returns a snapshot of the execution statistics of each job
+// the Runner has run, keyed by job name. It is safe to call while the
+// Runner is running.
+func (r *Runner) Stats() map[string]JobStat {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := make(map[string]JobStat, len(r.stats))
+	for name, stat := range r.stats {
+		stats[name] = *stat
+	}
+	return stats
+}
+
+// recordResult records the result of a run of a job
+func (r *Runner) recordResult(name string, res interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stat(name).LastResult = res
+}
+
+// stat returns the stats for the named job, r.mu must be held
+func (r *Runner) stat(name string) *JobStat {
+	stat, ok := r.stats[name]
+	if !ok {
+		stat = &JobStat{}
+		r.stats[name] = stat
+	}
+	return stat
+}
+
+// recordSkip records a run of a job that returned ErrSkipped
+func (r *Runner) recordSkip(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stat(name).Skipped++
+}
+
+// recordStat records a run of a job in the Runner's stats, returning
+// the number of consecutive failures of the job
+func (r *Runner) recordStat(name string, start time.Time, dur time.Duration, err error) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stat := r.stat(name)
+	stat.LastRun = start
+	stat.LastDuration = dur
+	stat.LastError = err
+	stat.Runs++
+	if err != nil {
+		stat.Failures++
+		stat.ConsecutiveFailures++
+	} else {
+		stat.ConsecutiveFailures = 0
+	}
+	return stat.ConsecutiveFailures
+}
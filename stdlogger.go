@@ -0,0 +1,34 @@
+package ensureinterval
+
+import (
+	"fmt"
+	"log"
+)
+
+// StdLogger adapts a standard library *log.Logger for use with
+// SetLogger or WithLogger. Debug messages are printed to the same
+// logger with a "[debug] " prefix, and job failures with an "[error] "
+// prefix.
+func StdLogger(l *log.Logger) Logger {
+	return &stdLogger{l}
+}
+
+type stdLogger struct {
+	l *log.Logger
+}
+
+func (s *stdLogger) Debug(a ...interface{}) {
+	s.l.Print("[debug] " + fmt.Sprint(a...))
+}
+
+func (s *stdLogger) Debugf(f string, a ...interface{}) {
+	s.l.Printf("[debug] "+f, a...)
+}
+
+func (s *stdLogger) Printf(f string, a ...interface{}) {
+	s.l.Printf(f, a...)
+}
+
+func (s *stdLogger) Errorf(f string, a ...interface{}) {
+	s.l.Printf("[error] "+f, a...)
+}
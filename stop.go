@@ -0,0 +1,110 @@
+package ensureinterval
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Stop tells a running Runner to stop once the jobs of the current
+// interval have finished. The Runner's Run then waits for any jobs
+// still running to finish and returns nil. Use Done to wait for it, or
+// StopWithTimeout to bound how long the jobs are waited for. A stopped
+// Runner can not be run again.
+func (r *Runner) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+}
+
+// StopWithTimeout stops the Runner like Stop, but also cancels the
+// context passed to running jobs, and waits at most d for them to
+// finish. If any are still running after d, an error matching
+// ErrDrainTimeout is returned with their names, and the Runner's Run
+// returns without waiting for them any longer. Errors from jobs failing
+// because they were cancelled are not returned by Run.
+func (r *Runner) StopWithTimeout(d time.Duration) error {
+	r.Stop()
+	r.cancelDrain(errDraining)
+	idle := make(chan struct{})
+	go func() {
+		r.drain()
+		close(idle)
+	}()
+	timeout, stop := r.after(d)
+	defer stop()
+	select {
+	case <-idle:
+		return nil
+	case <-timeout:
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(r.runningJobs))
+	for name := range r.runningJobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	r.drainExpired = true
+	r.idle.Broadcast()
+	return errors.Wrapf(ErrDrainTimeout, "after %s, still running: %s", d, strings.Join(names, ", "))
+}
+
+// Done returns a channel that is closed once the Runner has stopped
+// running.
+func (r *Runner) Done() <-chan struct{} {
+	return r.done
+}
+
+// stopAfter stops the Runner once d has passed, unless it is done
+// before then
+func (r *Runner) stopAfter(d time.Duration) {
+	wait, stop := r.after(d)
+	defer stop()
+	select {
+	case <-wait:
+		r.debugf("max runtime of %s reached, stopping", d)
+		r.Stop()
+	case <-r.done:
+	}
+}
+
+// stopped returns true if Stop has been called
+func (r *Runner) stopped() bool {
+	select {
+	case <-r.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// drain waits for all running jobs to finish, or the timeout given to
+// StopWithTimeout to pass
+func (r *Runner) drain() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for r.running > 0 && !r.drainExpired {
+		r.idle.Wait()
+	}
+}
+
+// exit is deferred by the run loops to mark the Runner done, draining
+// the running jobs first if drain is true, or it was stopped or its
+// context cancelled
+func (r *Runner) exit(drain bool) {
+	r.mu.Lock()
+	r.active = false
+	r.mu.Unlock()
+	if drain || r.stopped() || (r.baseCtx != nil && r.baseCtx.Err() != nil) {
+		r.drain()
+	}
+	r.doneOnce.Do(func() {
+		close(r.done)
+	})
+}
@@ -0,0 +1,74 @@
+package ensureinterval
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RunSupervised is the same as Run, but when Run stops with a
+// temporary error, such as when the max catchups are reached, it waits
+// for restartDelay and starts running again. Other errors are returned
+// straight away.
+func RunSupervised(interval time.Duration, getJobs JobLoader, restartDelay time.Duration) error {
+	return defaultRunner.RunSupervised(interval, getJobs, restartDelay)
+}
+
+// RunSupervisedContext is the same as RunSupervised, but will stop and
+// return ctx.Err() once the provided context is cancelled.
+func RunSupervisedContext(ctx context.Context, interval time.Duration, getJobs JobLoader, restartDelay time.Duration) error {
+	return defaultRunner.RunSupervisedContext(ctx, interval, getJobs, restartDelay)
+}
+
+// RunSupervised runs the jobs using the settings of the Runner,
+// restarting on temporary errors. See the package level RunSupervised
+// for details.
+func (r *Runner) RunSupervised(interval time.Duration, getJobs JobLoader, restartDelay time.Duration) error {
+	return r.RunSupervisedContext(context.Background(), interval, getJobs, restartDelay)
+}
+
+// RunSupervisedContext is the same as RunSupervised, but will stop and
+// return ctx.Err() once the provided context is cancelled.
+func (r *Runner) RunSupervisedContext(ctx context.Context, interval time.Duration, getJobs JobLoader, restartDelay time.Duration) error {
+	if err := checkInterval(interval); err != nil {
+		return err
+	}
+	ctx, release := r.bindContext(ctx)
+	defer release()
+	return contextErr(ctx, r.runSupervised(ctx, interval, getJobs, restartDelay))
+}
+
+// runSupervised runs the restart loop for RunSupervisedContext
+func (r *Runner) runSupervised(ctx context.Context, interval time.Duration, getJobs JobLoader, restartDelay time.Duration) error {
+	r.setStarted()
+	defer r.exit(false)
+	if ok, err := r.waitAlignStart(ctx, interval); !ok {
+		return err
+	}
+	for start := true; ; start = false {
+		err := r.loop(ctx, interval, getJobs, -1, start)
+		if !temporary(err) {
+			return err
+		}
+		r.logf("restarting in %s after temporary error: %s", restartDelay, err)
+		wait, stop := r.after(restartDelay)
+		select {
+		case <-ctx.Done():
+			stop()
+			return ctx.Err()
+		case <-r.stop:
+			stop()
+			return nil
+		case <-wait:
+		}
+	}
+}
+
+// temporary returns true if err says it is temporary
+func temporary(err error) bool {
+	var t interface {
+		Temporary() bool
+	}
+	return errors.As(err, &t) && t.Temporary()
+}
@@ -0,0 +1,171 @@
+package ensureinterval
+
+import (
+	"context"
+	"time"
+)
+
+// RunTicker will run the Jobs provided at the specified interval like
+// Run, but schedules the intervals with a time.Ticker started on an
+// interval boundary, so runs stay phase locked to the wall clock.
+//
+// Run measures how long each interval took and sleeps for the rest of
+// it, which corrects for slow jobs but lets small measuring errors add
+// up over time. RunTicker doesn't drift, but a ticker drops ticks it
+// can't deliver, so when an interval overruns the missed boundaries
+// are worked out from the clock and caught up before the current one.
+//
+// If the system clock is set forward or backward by more than an
+// interval, RunTicker carries on from the new time without catching up
+// the skipped time or waiting for the repeated time to pass.
+func RunTicker(interval time.Duration, getJobs JobLoader) error {
+	return defaultRunner.RunTicker(interval, getJobs)
+}
+
+// RunTickerContext is the same as RunTicker, but will stop and return
+// ctx.Err() once the provided context is cancelled.
+func RunTickerContext(ctx context.Context, interval time.Duration, getJobs JobLoader) error {
+	return defaultRunner.RunTickerContext(ctx, interval, getJobs)
+}
+
+// RunTicker will run the Jobs provided using the settings of the
+// Runner. See the package level RunTicker for details.
+func (r *Runner) RunTicker(interval time.Duration, getJobs JobLoader) error {
+	return r.RunTickerContext(context.Background(), interval, getJobs)
+}
+
+// RunTickerContext is the same as RunTicker, but will stop and return
+// ctx.Err() once the provided context is cancelled.
+func (r *Runner) RunTickerContext(ctx context.Context, interval time.Duration, getJobs JobLoader) error {
+	if err := checkInterval(interval); err != nil {
+		return err
+	}
+	ctx, release := r.bindContext(ctx)
+	defer release()
+	return contextErr(ctx, r.runTicker(ctx, interval, getJobs))
+}
+
+// runTicker runs the ticker loop for RunTickerContext
+func (r *Runner) runTicker(ctx context.Context, interval time.Duration, getJobs JobLoader) error {
+	r.setStarted()
+	defer r.exit(false)
+	if ok, err := r.waitAlignStart(ctx, interval); !ok {
+		return err
+	}
+	if err := r.startupCheck(ctx, interval, getJobs); err != nil {
+		return err
+	}
+	if err := r.resume(ctx, interval, getJobs); err != nil {
+		return err
+	}
+	lastRead := r.clock.Now()
+	last := r.truncate(lastRead, interval)
+	r.tick(lastRead, last)
+	jobs, err := r.loadJobs(interval, getJobs)
+	if err != nil {
+		return err
+	}
+	if err := r.checkNoJobs(last, jobs); err != nil {
+		return err
+	}
+	kind := runScheduled
+	if r.runOnStart {
+		kind = runStart
+	}
+	if err := r.jobsError(ctx, r.processJobs(ctx, last, interval, jobs, kind)); err != nil {
+		return err
+	}
+	r.tickComplete(last, r.clock.Now().Sub(last), interval)
+
+	// start the ticker on the next boundary so it stays in phase
+	wait, stop := r.after(r.nextBoundary(last, interval).Sub(r.clock.Now()))
+	select {
+	case <-ctx.Done():
+		stop()
+		return ctx.Err()
+	case <-r.stop:
+		stop()
+		return nil
+	case <-wait:
+	}
+	lastRead = r.clock.Now()
+	ticker := r.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	now := r.nextBoundary(last, interval)
+	for {
+		r.tick(r.clock.Now(), now)
+		jobs, err := r.loadJobs(interval, getJobs)
+		if err != nil {
+			return err
+		}
+		if err := r.checkNoJobs(now, jobs); err != nil {
+			return err
+		}
+		if !r.catchup && r.nextBoundary(last, interval).Before(now) {
+			r.debugf("skipping %d missed intervals", now.Sub(last)/interval-1)
+			last = now.Add(-interval)
+		}
+		if next := r.nextBoundary(last, interval); next.Before(now) {
+			for _, f := range r.onCatchup {
+				f(int(now.Sub(next)/interval), r.clock.Now().Sub(next))
+			}
+		}
+		var ketchups []time.Time
+		exceeded := false
+		for ketchup := r.nextBoundary(last, interval); ketchup.Before(now); ketchup = r.nextBoundary(ketchup, interval) {
+			if int64(len(ketchups)) >= r.maxCatchups.Load() {
+				exceeded = true
+				break
+			}
+			ketchups = append(ketchups, ketchup)
+		}
+		if err := r.processCatchups(ctx, ketchups, interval, jobs); err != nil {
+			return err
+		}
+		if err := r.runCoalesced(ctx, interval); err != nil {
+			return err
+		}
+		if exceeded && !r.stopped() {
+			caught := last
+			if len(ketchups) > 0 {
+				caught = ketchups[len(ketchups)-1]
+			}
+			behind := r.clock.Now().Sub(r.nextBoundary(caught, interval))
+			if err := r.catchupExceeded(len(ketchups), behind); err != nil {
+				return err
+			}
+		}
+		if r.stopped() {
+			return nil
+		}
+		if err := r.jobsError(ctx, r.processJobs(ctx, now, interval, jobs, runScheduled)); err != nil {
+			return err
+		}
+		r.tickComplete(now, r.clock.Now().Sub(now), interval)
+		last = now
+
+		for !now.After(last) {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-r.stop:
+				return nil
+			case <-ticker.Chan():
+				// a tick may have waited in the channel, so go by the
+				// clock rather than the tick time
+				read := r.clock.Now()
+				now = r.truncate(read, interval)
+				// if the wall clock moved more than an interval more or
+				// less than the monotonic clock since the last tick, it
+				// was set, so start again from the new time rather than
+				// catching up or waiting for it to come back around
+				if jump := now.Sub(last) - read.Sub(lastRead); jump > interval || jump < -interval {
+					r.logf("clock jumped by %s, resyncing", jump)
+					last = now.Add(-interval)
+				}
+				lastRead = read
+			}
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package ensureinterval
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Trigger runs the named job now, outside of its normal schedule, and
+// returns the error from running it. The run is for the current
+// interval. The job must be in the set last loaded by the Runner. If
+// the job is disabled an error matching ErrJobDisabled is returned, if
+// it is done one matching ErrJobDone, and if it has NoOverlap set and
+// is already running one matching ErrJobRunning.
+func (r *Runner) Trigger(name string) error {
+	job, interval, err := r.findJob(name)
+	if err != nil {
+		return err
+	}
+	if r.isDisabled(job) {
+		return errors.Wrapf(ErrJobDisabled, "job %s", name)
+	}
+	if r.isFinished(name) {
+		return errors.Wrapf(ErrJobDone, "job %s", name)
+	}
+	if !r.acquire(job) {
+		return errors.Wrapf(ErrJobRunning, "job %s", name)
+	}
+	return r.runJob(r.baseContext(), job, r.truncate(r.clock.Now(), interval), interval)
+}
+
+// TriggerAll runs every job last loaded by the Runner now, regardless
+// of their schedule, and returns a *JobErrors if any fail. Disabled
+// and done jobs, and NoOverlap jobs that are already running, are
+// skipped. The regular schedule is not affected.
+func (r *Runner) TriggerAll() error {
+	return r.triggerJobs(func(*Job) bool {
+		return true
+	})
+}
+
+// findJob returns the named job from the last loaded jobs, along with
+// the interval they were loaded for
+func (r *Runner) findJob(name string) (*Job, time.Duration, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, j := range r.jobs {
+		if j.Name == name {
+			return j, r.interval, nil
+		}
+	}
+	return nil, 0, errors.Wrapf(ErrUnknownJob, "job %s", name)
+}
+
+// Reload calls the JobLoader the Runner is running with and swaps in
+// the jobs it returns, which are used from the next interval. It is
+// most useful with WithLoaderInterval, to pick up changes before the
+// next load. An error from the loader or an invalid job is returned
+// and the Runner keeps running the jobs it has. ErrNotRunning is
+// returned if the Runner isn't running.
+func (r *Runner) Reload() error {
+	r.mu.Lock()
+	active, getJobs, interval := r.active, r.loader, r.interval
+	r.mu.Unlock()
+	if !active || getJobs == nil {
+		return ErrNotRunning
+	}
+	_, err := r.fetchJobs(interval, getJobs)
+	return err
+}
+
+// setJobs records the jobs last loaded and the interval they are run
+// with
+func (r *Runner) setJobs(interval time.Duration, jobs []*Job) {
+	now := r.truncate(r.clock.Now(), interval)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interval = interval
+	r.jobs = jobs
+	r.loadedAt = now
+}
@@ -0,0 +1,44 @@
+package ensureinterval
+
+import (
+	"context"
+)
+
+// TypedJob is a Job whose Exec returns a result of type T, which is
+// kept as the LastResult in the Runner's Stats and can be read back
+// with Results. Use AsJob to get the Job to give to a Runner, alongside
+// any other jobs.
+type TypedJob[T any] struct {
+	// Job holds the settings of the job. Its Exec and Result are
+	// replaced by Exec.
+	Job
+	// Exec is the function that is run for the job
+	Exec func(ctx context.Context) (T, error)
+}
+
+// AsJob returns the Job to run for t. If t has no Name it is named
+// after its Exec when it is loaded, the same as a Job is.
+func (t *TypedJob[T]) AsJob() *Job {
+	job := t.Job
+	job.namedFor = t.Exec
+	job.Exec = nil
+	job.Result = func(ctx context.Context) (interface{}, error) {
+		return t.Exec(ctx)
+	}
+	return &job
+}
+
+// Results returns the result of the last successful run of the named
+// job on r, and true if there is one. False is returned if the job
+// hasn't returned a result yet, or its last result isn't a T.
+func Results[T any](r *Runner, name string) (T, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var res T
+	stat, ok := r.stats[name]
+	if !ok {
+		return res, false
+	}
+	res, ok = stat.LastResult.(T)
+	return res, ok
+}